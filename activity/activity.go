@@ -0,0 +1,110 @@
+package activity
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hongjie104/leaf/timer"
+)
+
+// an activity is open during its windows
+// without Cron, the only window is [Start, End)
+// with Cron, a window of Duration opens on each fire of Cron in [Start, End),
+// a zero End never closes the activity
+type Activity struct {
+	ID       string
+	Start    time.Time
+	End      time.Time
+	Cron     string
+	Duration time.Duration
+	// optional, the time zone of Cron unless set by TZ=
+	Location *time.Location
+	expr     *timer.CronExpr
+}
+
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+func (a *Activity) init() error {
+	if a.ID == "" {
+		return errors.New("activity id required")
+	}
+
+	if a.Cron == "" {
+		if a.End.IsZero() || !a.End.After(a.Start) {
+			return fmt.Errorf("activity %v: invalid end %v", a.ID, a.End)
+		}
+		return nil
+	}
+
+	if a.Duration <= 0 {
+		return fmt.Errorf("activity %v: invalid duration %v", a.ID, a.Duration)
+	}
+	if !a.End.IsZero() && !a.End.After(a.Start) {
+		return fmt.Errorf("activity %v: invalid end %v", a.ID, a.End)
+	}
+	expr, err := timer.NewCronExprIn(a.Cron, a.Location)
+	if err != nil {
+		return fmt.Errorf("activity %v: %v", a.ID, err)
+	}
+	a.expr = expr
+	return nil
+}
+
+func (a *Activity) window(start time.Time) Window {
+	w := Window{start, start.Add(a.Duration)}
+	if !a.End.IsZero() && w.End.After(a.End) {
+		w.End = a.End
+	}
+	return w
+}
+
+// the first fire of Cron after t, not before Start
+func (a *Activity) fire(t time.Time) (time.Time, bool) {
+	if from := a.Start.Add(-time.Nanosecond); t.Before(from) {
+		t = from
+	}
+	next := a.expr.Next(t)
+	if next.IsZero() || !a.End.IsZero() && !next.Before(a.End) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// the window open at t, the earliest one if they overlap
+func (a *Activity) activeAt(t time.Time) (Window, bool) {
+	if a.expr == nil {
+		w := Window{a.Start, a.End}
+		return w, w.Contains(t)
+	}
+
+	start, ok := a.fire(t.Add(-a.Duration))
+	if !ok || start.After(t) {
+		return Window{}, false
+	}
+	w := a.window(start)
+	return w, w.Contains(t)
+}
+
+// the first window opening after t
+func (a *Activity) nextAfter(t time.Time) (Window, bool) {
+	if a.expr == nil {
+		if a.Start.After(t) {
+			return Window{a.Start, a.End}, true
+		}
+		return Window{}, false
+	}
+
+	start, ok := a.fire(t)
+	if !ok {
+		return Window{}, false
+	}
+	return a.window(start), true
+}
@@ -0,0 +1,151 @@
+package activity
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hongjie104/leaf/timer"
+)
+
+type entry struct {
+	a      *Activity
+	active bool
+	w      Window
+	t      *timer.Timer
+}
+
+func (e *entry) stop() {
+	if e.t != nil {
+		e.t.Stop()
+		e.t = nil
+	}
+}
+
+// the callbacks run on the dispatcher goroutine
+// one calendar per dispatcher (goroutine not safe)
+type Calendar struct {
+	// called when a window opens, or on Add if it is already open
+	OnStart func(a *Activity, w Window)
+	// called when a window closes
+	OnEnd   func(a *Activity, w Window)
+	disp    *timer.Dispatcher
+	entries map[string]*entry
+}
+
+func NewCalendar(disp *timer.Dispatcher) *Calendar {
+	c := new(Calendar)
+	c.disp = disp
+	c.entries = make(map[string]*entry)
+	return c
+}
+
+// an activity in the middle of a window starts at once
+func (c *Calendar) Add(a *Activity) error {
+	if err := a.init(); err != nil {
+		return err
+	}
+	if _, ok := c.entries[a.ID]; ok {
+		return fmt.Errorf("activity %v: already added", a.ID)
+	}
+
+	e := &entry{a: a}
+	c.entries[a.ID] = e
+	c.schedule(e)
+	return nil
+}
+
+// OnEnd is not called
+func (c *Calendar) Remove(id string) {
+	if e, ok := c.entries[id]; ok {
+		e.stop()
+		delete(c.entries, id)
+	}
+}
+
+// remove all the activities
+func (c *Calendar) Stop() {
+	for id := range c.entries {
+		c.Remove(id)
+	}
+}
+
+func (c *Calendar) Activity(id string) *Activity {
+	if e, ok := c.entries[id]; ok {
+		return e.a
+	}
+	return nil
+}
+
+// the ids of the open activities, sorted
+func (c *Calendar) Actives() []string {
+	var ids []string
+	for id, e := range c.entries {
+		if e.active {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// the window of the activity open now
+func (c *Calendar) Active(id string) (Window, bool) {
+	return c.ActiveAt(id, c.disp.Clock().Now())
+}
+
+func (c *Calendar) ActiveAt(id string, t time.Time) (Window, bool) {
+	e, ok := c.entries[id]
+	if !ok {
+		return Window{}, false
+	}
+	return e.a.activeAt(t)
+}
+
+// the next window of the activity opening after now
+func (c *Calendar) Next(id string) (Window, bool) {
+	return c.NextAfter(id, c.disp.Clock().Now())
+}
+
+func (c *Calendar) NextAfter(id string, t time.Time) (Window, bool) {
+	e, ok := c.entries[id]
+	if !ok {
+		return Window{}, false
+	}
+	return e.a.nextAfter(t)
+}
+
+func (c *Calendar) schedule(e *entry) {
+	now := c.disp.Clock().Now()
+
+	if w, ok := e.a.activeAt(now); ok {
+		e.active = true
+		e.w = w
+		e.t = c.disp.AfterFunc(w.End.Sub(now), func() {
+			c.end(e)
+		})
+		if c.OnStart != nil {
+			c.OnStart(e.a, w)
+		}
+		return
+	}
+
+	if w, ok := e.a.nextAfter(now); ok {
+		e.t = c.disp.AfterFunc(w.Start.Sub(now), func() {
+			c.schedule(e)
+		})
+	}
+}
+
+func (c *Calendar) end(e *entry) {
+	e.active = false
+	e.t = nil
+	// the window closes before the next one opens, back to back or not
+	if c.OnEnd != nil {
+		c.OnEnd(e.a, e.w)
+	}
+	// OnEnd may remove the activity
+	if c.entries[e.a.ID] == e {
+		c.schedule(e)
+	}
+}
@@ -0,0 +1,59 @@
+package activity_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/activity"
+	"github.com/hongjie104/leaf/timer"
+)
+
+func TestCalendarContiguous(t *testing.T) {
+	start := time.Date(2021, 6, 5, 0, 30, 0, 0, time.UTC)
+	clock := timer.NewFakeClock(start)
+	d := timer.NewDispatcher(10)
+	d.SetClock(clock)
+
+	var got []string
+	c := activity.NewCalendar(d)
+	c.OnStart = func(a *activity.Activity, w activity.Window) {
+		got = append(got, fmt.Sprint("start ", w.Start.Format("15:04"), "-", w.End.Format("15:04")))
+	}
+	c.OnEnd = func(a *activity.Activity, w activity.Window) {
+		got = append(got, fmt.Sprint("end ", w.Start.Format("15:04"), "-", w.End.Format("15:04")))
+		if w.End.Hour() == 3 {
+			c.Remove(a.ID)
+		}
+	}
+
+	// every hour for an hour, the windows back to back
+	err := c.Add(&activity.Activity{
+		ID:       "hourly",
+		Start:    start.Add(-time.Hour),
+		Cron:     "0 * * * *",
+		Duration: time.Hour,
+		Location: time.UTC,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.AdvanceAndDispatch(5*time.Hour, d)
+
+	// removed by OnEnd, the next window never opens
+	want := []string{
+		"start 00:00-01:00",
+		"end 00:00-01:00",
+		"start 01:00-02:00",
+		"end 01:00-02:00",
+		"start 02:00-03:00",
+		"end 02:00-03:00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("callbacks %q, want %q", got, want)
+	}
+	if clock.Len() != 0 || c.Activity("hourly") != nil {
+		t.Fatalf("timers: %v", clock.Len())
+	}
+}
@@ -0,0 +1,73 @@
+package activity_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hongjie104/leaf/activity"
+	"github.com/hongjie104/leaf/timer"
+)
+
+func ExampleCalendar() {
+	// saturday noon
+	clock := timer.NewFakeClock(time.Date(2021, 6, 5, 12, 0, 0, 0, time.UTC))
+	d := timer.NewDispatcher(10)
+	d.SetClock(clock)
+
+	c := activity.NewCalendar(d)
+	c.OnStart = func(a *activity.Activity, w activity.Window) {
+		fmt.Println(clock.Now().Format("Mon 15:04"), "start", a.ID, w.Start.Format("Mon 15:04"))
+	}
+	c.OnEnd = func(a *activity.Activity, w activity.Window) {
+		fmt.Println(clock.Now().Format("Mon 15:04"), "end", a.ID)
+	}
+
+	// booted in the middle of the window
+	c.Add(&activity.Activity{
+		ID:    "double_xp",
+		Start: time.Date(2021, 6, 5, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2021, 6, 7, 0, 0, 0, 0, time.UTC),
+	})
+	// every saturday at 20:00 for 2 hours
+	c.Add(&activity.Activity{
+		ID:       "guild_war",
+		Start:    time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		Cron:     "0 20 * * SAT",
+		Duration: 2 * time.Hour,
+		Location: time.UTC,
+	})
+
+	w, _ := c.Next("guild_war")
+	fmt.Println("next guild_war", w.Start.Format("Mon 15:04"))
+
+	clock.AdvanceAndDispatch(48*time.Hour, d)
+	fmt.Println(c.Actives())
+
+	w, _ = c.Next("guild_war")
+	fmt.Println("next guild_war", w.Start.Format("Jan 2"))
+
+	// Output:
+	// Sat 12:00 start double_xp Sat 00:00
+	// next guild_war Sat 20:00
+	// Sat 20:00 start guild_war Sat 20:00
+	// Sat 22:00 end guild_war
+	// Mon 00:00 end double_xp
+	// []
+	// next guild_war Jun 12
+}
+
+func ExampleLoad() {
+	activities, err := activity.Load("test.txt", time.UTC)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, a := range activities {
+		fmt.Println(a.ID, a.Start, a.End.IsZero(), a.Cron, a.Duration)
+	}
+
+	// Output:
+	// double_xp 2021-06-05 00:00:00 +0000 UTC false  0s
+	// guild_war 2021-06-01 00:00:00 +0000 UTC true 0 20 * * SAT 2h0m0s
+}
@@ -0,0 +1,76 @@
+package activity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hongjie104/leaf/recordfile"
+)
+
+// a row of an activity table
+//
+//	ID	Start	End	Cron	Duration
+//	double_xp	2021-06-05 00:00:00	2021-06-07 00:00:00
+//	guild_war	2021-06-01 00:00:00		0 20 * * SAT	2h
+//
+// Start, End and Cron are in the location given to Load, End and Cron may
+// be empty
+type Record struct {
+	ID       string
+	Start    string
+	End      string
+	Cron     string
+	Duration string
+}
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// read the activities of a recordfile table
+func Load(name string, loc *time.Location) ([]*Activity, error) {
+	rf, err := recordfile.New(Record{})
+	if err != nil {
+		return nil, err
+	}
+	err = rf.Read(name)
+	if err != nil {
+		return nil, err
+	}
+
+	activities := make([]*Activity, rf.NumRecord())
+	for i := 0; i < rf.NumRecord(); i++ {
+		a, err := parseRecord(rf.Record(i).(*Record), loc)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+		activities[i] = a
+	}
+	return activities, nil
+}
+
+func parseRecord(r *Record, loc *time.Location) (*Activity, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	a := &Activity{ID: r.ID, Cron: r.Cron, Location: loc}
+
+	var err error
+	a.Start, err = time.ParseInLocation(timeLayout, r.Start, loc)
+	if err != nil {
+		return nil, fmt.Errorf("activity %v: invalid start: %v", r.ID, err)
+	}
+	if r.End != "" {
+		a.End, err = time.ParseInLocation(timeLayout, r.End, loc)
+		if err != nil {
+			return nil, fmt.Errorf("activity %v: invalid end: %v", r.ID, err)
+		}
+	}
+	if r.Duration != "" {
+		a.Duration, err = time.ParseDuration(r.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("activity %v: invalid duration: %v", r.ID, err)
+		}
+	}
+
+	return a, a.init()
+}
@@ -0,0 +1,360 @@
+// Package actor multiplexes lightweight actors onto a skeleton or workers.
+//
+// Only the local actors are served. Go reaches the actors of other nodes
+// through a Remote set by the application, Call0, Call1 and CallN never
+// leave the node. No Remote is provided on the cluster transport, which
+// does not carry messages yet.
+package actor
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongjie104/leaf/chanrpc"
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/timer"
+)
+
+// messages handled by an actor in one go before yielding the executor
+const batch = 64
+
+// address of an actor, Node is empty for a local actor
+type PID struct {
+	Node string
+	ID   string
+}
+
+func (pid PID) String() string {
+	if pid.Node == "" {
+		return pid.ID
+	}
+	return pid.Node + "/" + pid.ID
+}
+
+// delivers the messages sent with Go to the actors living on other nodes
+// not provided on the cluster transport, the remote calls are not supported
+type Remote interface {
+	// must goroutine safe
+	Go(pid PID, id interface{}, args []interface{}) error
+}
+
+// many actors multiplexed onto one executor
+type System struct {
+	// name of the local node
+	Node string
+	// used for the PIDs of the other nodes, optional
+	Remote     Remote
+	MailboxLen int
+	TimerLen   int
+	exec       Executor
+	actors     map[string]*Actor
+	spawning   map[string]struct{}
+	mutex      sync.RWMutex
+}
+
+// one actor is run by at most one goroutine at a time
+type Actor struct {
+	pid        PID
+	sys        *System
+	server     *chanrpc.Server
+	dispatcher *timer.Dispatcher
+	scheduled  int32
+	stopped    int32
+	closeFlag  bool
+
+	// stopped when the actor stops
+	mutexTimers  sync.Mutex
+	timers       map[*timer.Timer]struct{}
+	crons        map[*timer.Cron]struct{}
+	timersClosed bool
+}
+
+func NewSystem(exec Executor) *System {
+	sys := new(System)
+	sys.MailboxLen = 100
+	sys.TimerLen = 10
+	sys.exec = exec
+	sys.actors = make(map[string]*Actor)
+	sys.spawning = make(map[string]struct{})
+	return sys
+}
+
+// init is called before the actor is reachable, register the handlers there
+// init is not called if id is taken
+// goroutine safe
+func (sys *System) Spawn(id string, init func(a *Actor)) (*Actor, error) {
+	sys.mutex.Lock()
+	_, spawning := sys.spawning[id]
+	if _, ok := sys.actors[id]; ok || spawning {
+		sys.mutex.Unlock()
+		return nil, fmt.Errorf("actor %v: already exists", id)
+	}
+	sys.spawning[id] = struct{}{}
+	sys.mutex.Unlock()
+
+	if sys.MailboxLen <= 0 {
+		sys.MailboxLen = 100
+		log.Infof("invalid MailboxLen, reset to %v", sys.MailboxLen)
+	}
+	if sys.TimerLen <= 0 {
+		sys.TimerLen = 10
+		log.Infof("invalid TimerLen, reset to %v", sys.TimerLen)
+	}
+
+	a := new(Actor)
+	a.pid = PID{Node: sys.Node, ID: id}
+	a.sys = sys
+	a.server = chanrpc.NewServer(sys.MailboxLen)
+	a.server.SetNotify(a.schedule)
+	a.dispatcher = timer.NewDispatcher(sys.TimerLen)
+	a.dispatcher.SetNotify(a.schedule)
+	a.timers = make(map[*timer.Timer]struct{})
+	a.crons = make(map[*timer.Cron]struct{})
+	if init != nil {
+		init(a)
+	}
+
+	sys.mutex.Lock()
+	defer sys.mutex.Unlock()
+	delete(sys.spawning, id)
+	sys.actors[id] = a
+	return a, nil
+}
+
+// goroutine safe
+func (sys *System) Actor(id string) *Actor {
+	sys.mutex.RLock()
+	defer sys.mutex.RUnlock()
+	return sys.actors[id]
+}
+
+// goroutine safe
+func (sys *System) Len() int {
+	sys.mutex.RLock()
+	defer sys.mutex.RUnlock()
+	return len(sys.actors)
+}
+
+func (sys *System) local(pid PID) bool {
+	return pid.Node == "" || pid.Node == sys.Node
+}
+
+func (sys *System) lookup(pid PID) (*Actor, error) {
+	if !sys.local(pid) {
+		return nil, fmt.Errorf("actor %v: remote call not supported, only Go reaches other nodes", pid)
+	}
+	a := sys.Actor(pid.ID)
+	if a == nil {
+		return nil, fmt.Errorf("actor %v: not found", pid)
+	}
+	return a, nil
+}
+
+// goroutine safe
+func (sys *System) Go(pid PID, id interface{}, args ...interface{}) error {
+	if !sys.local(pid) {
+		if sys.Remote == nil {
+			return fmt.Errorf("actor %v: remote not set", pid)
+		}
+		return sys.Remote.Go(pid, id, args)
+	}
+
+	a := sys.Actor(pid.ID)
+	if a == nil {
+		return fmt.Errorf("actor %v: not found", pid)
+	}
+	a.server.Go(id, args...)
+	return nil
+}
+
+// do not call it on the executor of the target actor
+// goroutine safe
+func (sys *System) Call0(pid PID, id interface{}, args ...interface{}) error {
+	a, err := sys.lookup(pid)
+	if err != nil {
+		return err
+	}
+	return a.server.Call0(id, args...)
+}
+
+// do not call it on the executor of the target actor
+// goroutine safe
+func (sys *System) Call1(pid PID, id interface{}, args ...interface{}) (interface{}, error) {
+	a, err := sys.lookup(pid)
+	if err != nil {
+		return nil, err
+	}
+	return a.server.Call1(id, args...)
+}
+
+// do not call it on the executor of the target actor
+// goroutine safe
+func (sys *System) CallN(pid PID, id interface{}, args ...interface{}) ([]interface{}, error) {
+	a, err := sys.lookup(pid)
+	if err != nil {
+		return nil, err
+	}
+	return a.server.CallN(id, args...)
+}
+
+// goroutine safe
+func (sys *System) Stop(id string) {
+	a := sys.Actor(id)
+	if a != nil {
+		a.Stop()
+	}
+}
+
+func (a *Actor) PID() PID {
+	return a.pid
+}
+
+func (a *Actor) System() *System {
+	return a.sys
+}
+
+// you must call the function in the init function of Spawn
+func (a *Actor) Register(id interface{}, f interface{}) {
+	a.server.Register(id, f)
+}
+
+// the mailbox, usable with chanrpc.Client and Skeleton.AsynCall
+func (a *Actor) Server() *chanrpc.Server {
+	return a.server
+}
+
+// goroutine safe
+func (a *Actor) Go(id interface{}, args ...interface{}) {
+	a.server.Go(id, args...)
+}
+
+// cb runs serialized with the messages of the actor, the timer is stopped
+// with the actor
+// goroutine safe
+func (a *Actor) AfterFunc(d time.Duration, cb func()) *timer.Timer {
+	a.mutexTimers.Lock()
+	defer a.mutexTimers.Unlock()
+
+	var t *timer.Timer
+	t = a.dispatcher.AfterFunc(d, func() {
+		a.mutexTimers.Lock()
+		delete(a.timers, t)
+		a.mutexTimers.Unlock()
+		cb()
+	})
+	if a.timersClosed {
+		t.Stop()
+	} else {
+		a.timers[t] = struct{}{}
+	}
+	return t
+}
+
+// cb runs serialized with the messages of the actor, the cron is stopped
+// with the actor
+// goroutine safe
+func (a *Actor) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
+	a.mutexTimers.Lock()
+	defer a.mutexTimers.Unlock()
+
+	c := a.dispatcher.CronFunc(cronExpr, cb)
+	if a.timersClosed {
+		c.Stop()
+	} else {
+		a.crons[c] = struct{}{}
+	}
+	return c
+}
+
+// called on the executor, serialized with the callbacks of the timers
+func (a *Actor) stopTimers() {
+	a.mutexTimers.Lock()
+	a.timersClosed = true
+	for t := range a.timers {
+		t.Stop()
+	}
+	for c := range a.crons {
+		c.Stop()
+	}
+	a.timers = nil
+	a.crons = nil
+	a.mutexTimers.Unlock()
+
+	// a fire may be queued already
+	for {
+		select {
+		case <-a.dispatcher.ChanTimer:
+		default:
+			return
+		}
+	}
+}
+
+// the pending calls fail with an error and the timers are stopped
+// goroutine safe
+func (a *Actor) Stop() {
+	if !atomic.CompareAndSwapInt32(&a.stopped, 0, 1) {
+		return
+	}
+
+	a.sys.mutex.Lock()
+	if a.sys.actors[a.pid.ID] == a {
+		delete(a.sys.actors, a.pid.ID)
+	}
+	a.sys.mutex.Unlock()
+
+	a.schedule()
+}
+
+func (a *Actor) Stopped() bool {
+	return atomic.LoadInt32(&a.stopped) == 1
+}
+
+func (a *Actor) schedule() {
+	if atomic.CompareAndSwapInt32(&a.scheduled, 0, 1) {
+		a.sys.exec.Post(a.run)
+	}
+}
+
+func (a *Actor) pending() bool {
+	return len(a.server.ChanCall) > 0 || len(a.dispatcher.ChanTimer) > 0
+}
+
+func (a *Actor) run() {
+	if a.closeFlag {
+		return
+	}
+
+	for i := 0; i < batch; i++ {
+		if a.Stopped() {
+			a.close()
+			return
+		}
+
+		select {
+		case ci := <-a.server.ChanCall:
+			a.server.Exec(ci)
+		case t := <-a.dispatcher.ChanTimer:
+			t.Cb()
+		default:
+			atomic.StoreInt32(&a.scheduled, 0)
+			if a.pending() || a.Stopped() {
+				a.schedule()
+			}
+			return
+		}
+	}
+
+	// yield to the other actors
+	atomic.StoreInt32(&a.scheduled, 0)
+	a.schedule()
+}
+
+// scheduled stays set, so the actor is never run again
+func (a *Actor) close() {
+	a.closeFlag = true
+	a.stopTimers()
+	a.server.Close()
+}
@@ -0,0 +1,142 @@
+package actor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/timer"
+)
+
+func TestSpawn(t *testing.T) {
+	w := NewWorkers(2)
+	defer w.Close()
+	sys := NewSystem(w)
+
+	if _, err := sys.Spawn("room", nil); err != nil {
+		t.Fatal(err)
+	}
+	inits := 0
+	if _, err := sys.Spawn("room", func(a *Actor) {
+		inits++
+	}); err == nil {
+		t.Fatal("duplicate actor spawned")
+	}
+	if inits != 0 {
+		t.Fatal("init of a duplicate actor called")
+	}
+	if sys.Len() != 1 {
+		t.Fatalf("%v actors, want 1", sys.Len())
+	}
+}
+
+func TestCall(t *testing.T) {
+	w := NewWorkers(2)
+	defer w.Close()
+	sys := NewSystem(w)
+
+	cast := make(chan int, 1)
+	var players int
+	sys.Spawn("room", func(a *Actor) {
+		a.Register("join", func(args []interface{}) interface{} {
+			players += args[0].(int)
+			return players
+		})
+		a.Register("notify", func(args []interface{}) {
+			cast <- players
+		})
+	})
+
+	pid := PID{ID: "room"}
+	if err := sys.Go(pid, "join", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.Go(pid, "notify"); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-cast; n != 2 {
+		t.Fatalf("cast saw %v players, want 2", n)
+	}
+	if n, err := sys.Call1(pid, "join", 1); err != nil || n != 3 {
+		t.Fatalf("call: %v, %v", n, err)
+	}
+	if err := sys.Go(PID{ID: "none"}, "join", 1); err == nil {
+		t.Fatal("cast to a missing actor")
+	}
+	if _, err := sys.Call1(PID{Node: "other", ID: "room"}, "join", 1); err == nil {
+		t.Fatal("call to a remote actor")
+	}
+}
+
+func TestStop(t *testing.T) {
+	w := NewWorkers(2)
+	sys := NewSystem(w)
+
+	a, _ := sys.Spawn("room", func(a *Actor) {
+		a.Register("ping", func(args []interface{}) interface{} {
+			return "pong"
+		})
+	})
+	if _, err := sys.Call1(a.PID(), "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	sys.Stop("room")
+	if !a.Stopped() || sys.Actor("room") != nil {
+		t.Fatal("actor not stopped")
+	}
+	if _, err := sys.Call1(a.PID(), "ping"); err == nil {
+		t.Fatal("call to a stopped actor")
+	}
+	w.Close()
+
+	// the id is free again
+	w = NewWorkers(1)
+	defer w.Close()
+	sys = NewSystem(w)
+	if _, err := sys.Spawn("room", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	w := NewWorkers(1)
+	sys := NewSystem(w)
+	clock := timer.NewFakeClock(time.Now())
+
+	fired := make(chan string, 2)
+	a, _ := sys.Spawn("room", func(a *Actor) {
+		a.dispatcher.SetClock(clock)
+	})
+	a.AfterFunc(time.Second, func() {
+		fired <- "first"
+	})
+	a.AfterFunc(time.Minute, func() {
+		fired <- "second"
+	})
+
+	clock.Advance(time.Second)
+	if s := <-fired; s != "first" {
+		t.Fatalf("fired %v", s)
+	}
+
+	a.Stop()
+	// the executor runs the stop before Close returns
+	w.Close()
+	if clock.Len() != 0 {
+		t.Fatalf("%v timers pending after stop", clock.Len())
+	}
+	clock.Advance(time.Hour)
+	select {
+	case s := <-fired:
+		t.Fatalf("fired %v after stop", s)
+	default:
+	}
+
+	// a timer armed after the stop never fires
+	a.AfterFunc(0, func() {
+		fired <- "late"
+	})
+	if clock.Len() != 0 {
+		t.Fatal("timer armed after stop")
+	}
+}
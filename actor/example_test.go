@@ -0,0 +1,43 @@
+package actor_test
+
+import (
+	"fmt"
+
+	"github.com/hongjie104/leaf/actor"
+)
+
+func Example() {
+	w := actor.NewWorkers(4)
+	sys := actor.NewSystem(w)
+
+	// one actor per room
+	for _, id := range []string{"room1", "room2"} {
+		var players int
+		sys.Spawn(id, func(a *actor.Actor) {
+			a.Register("join", func(args []interface{}) interface{} {
+				players += args[0].(int)
+				return players
+			})
+		})
+	}
+
+	for i := 0; i < 3; i++ {
+		sys.Go(actor.PID{ID: "room1"}, "join", 1)
+	}
+	n, err := sys.Call1(actor.PID{ID: "room1"}, "join", 1)
+	fmt.Println(n, err)
+
+	n, err = sys.Call1(actor.PID{ID: "room2"}, "join", 2)
+	fmt.Println(n, err)
+
+	sys.Stop("room2")
+	_, err = sys.Call1(actor.PID{ID: "room2"}, "join", 2)
+	fmt.Println(err)
+
+	w.Close()
+
+	// Output:
+	// 4 <nil>
+	// 2 <nil>
+	// actor room2: not found
+}
@@ -0,0 +1,97 @@
+package actor
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/hongjie104/leaf/conf"
+	"github.com/hongjie104/leaf/log"
+)
+
+// runs the actors, module.Skeleton is an executor
+type Executor interface {
+	// must goroutine safe
+	Post(f func())
+}
+
+// a fixed number of goroutines sharing an unbounded queue
+type Workers struct {
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	queue     []func()
+	closeFlag bool
+	wg        sync.WaitGroup
+}
+
+func NewWorkers(n int) *Workers {
+	if n <= 0 {
+		n = runtime.NumCPU()
+		log.Infof("invalid worker number, reset to %v", n)
+	}
+
+	w := new(Workers)
+	w.cond = sync.NewCond(&w.mutex)
+	w.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go w.run()
+	}
+	return w
+}
+
+func (w *Workers) run() {
+	defer w.wg.Done()
+
+	for {
+		w.mutex.Lock()
+		for len(w.queue) == 0 && !w.closeFlag {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mutex.Unlock()
+			return
+		}
+		f := w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		w.mutex.Unlock()
+
+		exec(f)
+	}
+}
+
+// goroutine safe
+func (w *Workers) Post(f func()) {
+	w.mutex.Lock()
+	if w.closeFlag {
+		w.mutex.Unlock()
+		return
+	}
+	w.queue = append(w.queue, f)
+	w.mutex.Unlock()
+	w.cond.Signal()
+}
+
+// the queued functions are run before Close returns
+func (w *Workers) Close() {
+	w.mutex.Lock()
+	w.closeFlag = true
+	w.mutex.Unlock()
+	w.cond.Broadcast()
+	w.wg.Wait()
+}
+
+func exec(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("%v: %s", r, buf[:l])
+			} else {
+				log.Errorf("%v", r)
+			}
+		}
+	}()
+
+	f()
+}
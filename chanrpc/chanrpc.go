@@ -20,6 +20,7 @@ type Server struct {
 	// func(args []interface{}) []interface{}
 	functions map[interface{}]interface{}
 	ChanCall  chan *CallInfo
+	notify    func()
 }
 
 type CallInfo struct {
@@ -81,6 +82,12 @@ func (s *Server) Register(id interface{}, f interface{}) {
 	s.functions[id] = f
 }
 
+// f is called each time a call has been queued, it must be goroutine safe
+// you must call the function before calling Open and Go
+func (s *Server) SetNotify(f func()) {
+	s.notify = f
+}
+
 func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 	if ci.chanRet == nil {
 		return
@@ -150,6 +157,9 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 		f:    f,
 		args: args,
 	}
+	if s.notify != nil {
+		s.notify()
+	}
 }
 
 // goroutine safe
@@ -209,8 +219,12 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 		case c.s.ChanCall <- ci:
 		default:
 			err = errors.New("chanrpc channel full")
+			return
 		}
 	}
+	if c.s.notify != nil {
+		c.s.notify()
+	}
 	return
 }
 
@@ -77,6 +77,41 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	commands = append(commands, c)
 }
 
+type FuncCommand struct {
+	_name string
+	_help string
+	f     func(args []string) string
+}
+
+func (c *FuncCommand) name() string {
+	return c._name
+}
+
+func (c *FuncCommand) help() string {
+	return c._help
+}
+
+func (c *FuncCommand) run(args []string) string {
+	return c.f(args)
+}
+
+// f runs on the console goroutine and must be goroutine safe
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterFunc(name string, help string, f func(args []string) string) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatalf("command %v is already registered", name)
+		}
+	}
+
+	c := new(FuncCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	commands = append(commands, c)
+}
+
 // help
 type CommandHelp struct{}
 
@@ -0,0 +1,128 @@
+package mongodb
+
+import (
+	"time"
+
+	"github.com/hongjie104/leaf/timer"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// a timer.JobStore and timer.Leaser shared by the nodes
+// the lease is kept in the collection collection.lease
+// goroutine safe
+type JobStore struct {
+	c          *DialContext
+	db         string
+	collection string
+}
+
+type jobDoc struct {
+	ID    string    `bson:"_id"`
+	Spec  string    `bson:"spec"`
+	Next  time.Time `bson:"next"`
+	Last  time.Time `bson:"last"`
+	Fires int       `bson:"fires"`
+}
+
+type leaseDoc struct {
+	ID     string    `bson:"_id"`
+	Owner  string    `bson:"owner"`
+	Expire time.Time `bson:"expire"`
+}
+
+const leaseID = "leader"
+
+func (c *DialContext) NewJobStore(db string, collection string) *JobStore {
+	js := new(JobStore)
+	js.c = c
+	js.db = db
+	js.collection = collection
+	return js
+}
+
+func (js *JobStore) Load() ([]*timer.JobState, error) {
+	s := js.c.Ref()
+	defer js.c.UnRef(s)
+
+	var docs []jobDoc
+	err := s.DB(js.db).C(js.collection).Find(nil).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*timer.JobState, len(docs))
+	for i, doc := range docs {
+		states[i] = &timer.JobState{
+			ID:    doc.ID,
+			Spec:  doc.Spec,
+			Next:  doc.Next,
+			Last:  doc.Last,
+			Fires: doc.Fires,
+		}
+	}
+	return states, nil
+}
+
+func (js *JobStore) Save(state *timer.JobState) error {
+	s := js.c.Ref()
+	defer js.c.UnRef(s)
+
+	_, err := s.DB(js.db).C(js.collection).UpsertId(state.ID, jobDoc{
+		ID:    state.ID,
+		Spec:  state.Spec,
+		Next:  state.Next,
+		Last:  state.Last,
+		Fires: state.Fires,
+	})
+	return err
+}
+
+func (js *JobStore) Delete(id string) error {
+	s := js.c.Ref()
+	defer js.c.UnRef(s)
+
+	err := s.DB(js.db).C(js.collection).RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// the clocks of the nodes must not drift apart by more than ttl
+func (js *JobStore) Lease(owner string, ttl time.Duration) (bool, error) {
+	s := js.c.Ref()
+	defer js.c.UnRef(s)
+
+	now := time.Now()
+	_, err := s.DB(js.db).C(js.collection+".lease").Upsert(bson.M{
+		"_id": leaseID,
+		"$or": []bson.M{
+			{"owner": owner},
+			{"expire": bson.M{"$lt": now}},
+		},
+	}, leaseDoc{
+		ID:     leaseID,
+		Owner:  owner,
+		Expire: now.Add(ttl),
+	})
+	// held by another owner, the upsert inserts a duplicate
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (js *JobStore) Release(owner string) error {
+	s := js.c.Ref()
+	defer js.c.UnRef(s)
+
+	err := s.DB(js.db).C(js.collection + ".lease").Remove(bson.M{
+		"_id":   leaseID,
+		"owner": owner,
+	})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
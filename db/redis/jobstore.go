@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"encoding/json"
+	"time"
+
+	red "github.com/garyburd/redigo/redis"
+	"github.com/hongjie104/leaf/timer"
+)
+
+// JobStore 定时任务存储，实现 timer.JobStore 与 timer.Leaser
+// 任务保存在 hash key 中，租约保存在 key:lease 中
+type JobStore struct {
+	c   *DialContext
+	key string
+}
+
+// 未被持有或由 owner 持有时续约
+var leaseScript = red.NewScript(1, `
+local v = redis.call("GET", KEYS[1])
+if v == false or v == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// 仅由 owner 持有时释放
+var releaseScript = red.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// NewJobStore 创建定时任务存储
+func (c *DialContext) NewJobStore(key string) *JobStore {
+	return &JobStore{c: c, key: key}
+}
+
+// Load 加载所有任务
+func (js *JobStore) Load() ([]*timer.JobState, error) {
+	values, err := red.StringMap(js.c.Exec("HGETALL", js.key))
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*timer.JobState, 0, len(values))
+	for _, v := range values {
+		state := new(timer.JobState)
+		if err := json.Unmarshal([]byte(v), state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Save 保存任务
+func (js *JobStore) Save(state *timer.JobState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = js.c.Exec("HSET", js.key, state.ID, data)
+	return err
+}
+
+// Delete 删除任务
+func (js *JobStore) Delete(id string) error {
+	_, err := js.c.Exec("HDEL", js.key, id)
+	return err
+}
+
+// Lease 获取或续约租约
+func (js *JobStore) Lease(owner string, ttl time.Duration) (bool, error) {
+	con := js.c.redisClient.Get()
+	defer con.Close()
+
+	ok, err := red.Int(leaseScript.Do(con, js.key+":lease", owner, int64(ttl/time.Millisecond)))
+	return ok == 1, err
+}
+
+// Release 释放租约
+func (js *JobStore) Release(owner string) error {
+	con := js.c.redisClient.Get()
+	defer con.Close()
+
+	_, err := releaseScript.Do(con, js.key+":lease", owner)
+	return err
+}
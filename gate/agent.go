@@ -14,3 +14,13 @@ type Agent interface {
 	UserData() interface{}
 	SetUserData(data interface{})
 }
+
+// the agents of Gate implement UserAgent, check it with a type assertion
+type UserAgent interface {
+	Agent
+	// the session id, stable across reconnects in session mode
+	ID() uint64
+	// bind a user id, the agent previously bound to it is returned
+	BindUser(userID interface{}) Agent
+	UserID() interface{}
+}
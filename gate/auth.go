@@ -0,0 +1,31 @@
+package gate
+
+import (
+	"reflect"
+
+	"github.com/hongjie104/leaf/log"
+)
+
+// the message msg is routed before a user is bound to the agent
+// you must call the function before calling Run
+func (gate *Gate) AllowBeforeAuth(msg interface{}) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("message pointer required")
+	}
+	if gate.preAuth == nil {
+		gate.preAuth = make(map[reflect.Type]struct{})
+	}
+	gate.preAuth[msgType] = struct{}{}
+}
+
+// false if the message is forbidden before the auth
+func (l *link) authorized(msg interface{}) bool {
+	if !l.gate.RequireAuth {
+		return true
+	}
+	if _, ok := l.gate.preAuth[reflect.TypeOf(msg)]; ok {
+		return true
+	}
+	return l.a.UserID() != nil
+}
@@ -0,0 +1,42 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+type Login struct {
+	Name string `sproto:"string,0,name=name"`
+}
+
+func TestAuth(t *testing.T) {
+	g := &Gate{RequireAuth: true}
+	g.AllowBeforeAuth(&Login{})
+	gate := startGate(t, g)
+	defer gate.stop()
+
+	// forbidden before the auth
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	a := <-gate.newAgent
+	c.writeMsg("hello")
+	select {
+	case closed := <-gate.closeAgent:
+		if closed != a {
+			t.Fatalf("agent %v closed, want %v", closed.ID(), a.ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not disconnected")
+	}
+
+	// allowed after the auth
+	c = dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	a = <-gate.newAgent
+	c.writeJSON(map[string]Login{"Login": {"alice"}})
+	a.BindUser(<-gate.login)
+	c.writeMsg("hello")
+	if got := <-gate.received; got != "hello" {
+		t.Fatalf("received %v, want hello", got)
+	}
+}
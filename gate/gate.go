@@ -1,15 +1,17 @@
 package gate
 
 import (
-	"fmt"
+	"crypto/rand"
 	"net"
 	"reflect"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hongjie104/leaf/chanrpc"
 	"github.com/hongjie104/leaf/log"
 	"github.com/hongjie104/leaf/network"
+	"github.com/hongjie104/leaf/util"
 )
 
 // Gate Gate
@@ -30,10 +32,81 @@ type Gate struct {
 	TCPAddr      string
 	LenMsgLen    int
 	LittleEndian bool
+	// TLS if set, else the session cipher if SessionCipher, see
+	// network.CipherConn
+	TCPCertFile   string
+	TCPKeyFile    string
+	SessionCipher bool
+	// the queued messages of a TCP connection are written together, see
+	// network.TCPServer, the websocket messages are written one by one
+	MaxBatchSize int
+	FlushDelay   time.Duration
+	// optional, the messages read are borrowed from the pool and released
+	// once routed, if the Processor is a network.BorrowProcessor
+	ReadBufferPool *network.BufferPool
+	// optional, replaces the length prefix of LenMsgLen
+	FrameCodec network.FrameCodec
+
+	// optional, the compression negotiated per connection, permessage-deflate
+	// for websocket
+	Compression *network.Compression
+
+	// session, each message is framed by a session header and a client
+	// reconnecting within SessionTimeout resumes its session
+	Session        bool
+	SessionTimeout time.Duration
+	// unacknowledged messages kept for the replay, the replay is paced to
+	// the free room of the write queue
+	MaxPendingMsg int
+	// idempotent keys remembered per session
+	DedupLen int
+
+	// timeouts, 0 for none
+	// the connection is closed if nothing is read for ReadIdleTimeout, the
+	// first message must be read within HandshakeTimeout
+	ReadIdleTimeout  time.Duration
+	HandshakeTimeout time.Duration
+	// if nothing is written for WriteIdleTimeout, a heartbeat is sent, a
+	// ping for websocket, an ACK in session mode or HeartbeatMsg otherwise
+	// the HeartbeatMsg of the clients are not routed
+	WriteIdleTimeout time.Duration
+	HeartbeatMsg     interface{}
+	// the agent is closed if no user is bound within LoginTimeout
+	LoginTimeout time.Duration
+
+	// only the messages allowed by AllowBeforeAuth are routed until a user
+	// is bound to the agent, the others close the connection
+	// LoginTimeout is the deadline of the auth
+	RequireAuth bool
+
+	// optional, see the messages of the agents
+	Tracer Tracer
+	// optional, record the messages of each agent in a file of RecordDir
+	RecordDir string
+
+	// rate limit of the messages per connection, every frame of a session
+	// included, and of the connections accepted per second
+	RateLimit   RateLimit
+	RateWarnMsg interface{}
+	AcceptRate  float64
+	AcceptBurst int
+
+	msgRateLimits map[reflect.Type]RateLimit
+	preAuth       map[reflect.Type]struct{}
+	rateStats     rateStats
+	tcpServer     *network.TCPServer
+	wsServer      *network.WSServer
+	agents        map[uint64]*agent
+	mutexAgents   sync.Mutex
+	lastID        uint64
+	users         map[interface{}]*agent
+	groups        map[string]map[*agent]struct{}
 }
 
 // Run Run
 func (gate *Gate) Run(closeSig chan bool) {
+	gate.init()
+
 	var wsServer *network.WSServer
 	if gate.WSAddr != "" {
 		wsServer = new(network.WSServer)
@@ -44,12 +117,14 @@ func (gate *Gate) Run(closeSig chan bool) {
 		wsServer.HTTPTimeout = gate.HTTPTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
+		wsServer.AcceptRate = gate.AcceptRate
+		wsServer.AcceptBurst = gate.AcceptBurst
+		if gate.Compression != nil {
+			wsServer.EnableCompression = true
+			wsServer.CompressionThreshold = gate.Compression.Threshold
+		}
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.newLink(conn)
 		}
 	}
 
@@ -62,15 +137,28 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.LenMsgLen = gate.LenMsgLen
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
+		tcpServer.MaxBatchSize = gate.MaxBatchSize
+		tcpServer.FlushDelay = gate.FlushDelay
+		tcpServer.ReadBufferPool = gate.ReadBufferPool
+		tcpServer.FrameCodec = gate.FrameCodec
+		tcpServer.CertFile = gate.TCPCertFile
+		tcpServer.KeyFile = gate.TCPKeyFile
+		tcpServer.SessionCipher = gate.SessionCipher
+		tcpServer.AcceptRate = gate.AcceptRate
+		tcpServer.AcceptBurst = gate.AcceptBurst
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
+			if gate.Compression != nil {
+				return gate.newLink(network.CompressServer(conn, gate.Compression))
 			}
-			return a
+			return gate.newLink(conn)
 		}
 	}
 
+	gate.mutexAgents.Lock()
+	gate.wsServer = wsServer
+	gate.tcpServer = tcpServer
+	gate.mutexAgents.Unlock()
+
 	if wsServer != nil {
 		wsServer.Start()
 	}
@@ -89,39 +177,270 @@ func (gate *Gate) Run(closeSig chan bool) {
 // OnDestroy OnDestroy
 func (gate *Gate) OnDestroy() {}
 
-type agent struct {
-	conn     network.Conn
-	gate     *Gate
-	userData interface{}
+func (gate *Gate) init() {
+	if gate.Session {
+		if gate.SessionTimeout <= 0 {
+			gate.SessionTimeout = 30 * time.Second
+			log.Infof("invalid SessionTimeout, reset to %v", gate.SessionTimeout)
+		}
+		if gate.MaxPendingMsg <= 0 {
+			gate.MaxPendingMsg = 100
+			log.Infof("invalid MaxPendingMsg, reset to %v", gate.MaxPendingMsg)
+		}
+		if gate.DedupLen <= 0 {
+			gate.DedupLen = 128
+			log.Infof("invalid DedupLen, reset to %v", gate.DedupLen)
+		}
+	}
+
+	gate.mutexAgents.Lock()
+	gate.agents = make(map[uint64]*agent)
+	gate.users = make(map[interface{}]*agent)
+	gate.groups = make(map[string]map[*agent]struct{})
+	gate.mutexAgents.Unlock()
+}
+
+// goroutine safe
+func (gate *Gate) Agent(id uint64) Agent {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	if a, ok := gate.agents[id]; ok {
+		return a
+	}
+	return nil
+}
+
+// the number of the sessions, detached ones included
+// goroutine safe
+func (gate *Gate) Len() int {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	return len(gate.agents)
 }
 
-func (a *agent) Run() {
+func (gate *Gate) getAgent(id uint64) *agent {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	return gate.agents[id]
+}
+
+func (gate *Gate) newAgent(conn network.Conn) *agent {
+	a := &agent{conn: conn, gate: gate, attached: true}
+	if gate.Session {
+		if _, err := rand.Read(a.token[:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	a.startLogin()
+	if gate.RecordDir != "" {
+		a.recorder = gate.newRecorder(a)
+	}
+
+	gate.mutexAgents.Lock()
+	gate.lastID++
+	a.id = gate.lastID
+	gate.agents[a.id] = a
+	gate.mutexAgents.Unlock()
+
+	return a
+}
+
+// the agent leaves its groups and its user is unbound
+func (gate *Gate) removeAgent(a *agent) {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	delete(gate.agents, a.id)
+	if a.userID != nil && gate.users[a.userID] == a {
+		delete(gate.users, a.userID)
+	}
+	for group := range a.groups {
+		gate.leave(group, a)
+	}
+}
+
+// a link serves one connection, the agent outlives it in session mode
+type link struct {
+	gate           *Gate
+	conn           network.Conn
+	a              *agent
+	releaser       network.MsgReleaser
+	rateLimit      *util.TokenBucket
+	msgRateLimits  map[reflect.Type]*util.TokenBucket
+	started        bool
+	deadline       bool
+	heartbeat      *time.Timer
+	mutexHeartbeat sync.Mutex
+}
+
+func (gate *Gate) newLink(conn network.Conn) *link {
+	l := &link{gate: gate, conn: conn}
+	if releaser, ok := conn.(network.MsgReleaser); ok {
+		if _, ok := gate.Processor.(network.BorrowProcessor); ok || gate.Processor == nil {
+			l.releaser = releaser
+		}
+	}
+	l.watchPong()
+	if !gate.Session {
+		l.a = gate.newAgent(conn)
+		if gate.AgentChanRPC != nil {
+			gate.AgentChanRPC.Go("NewAgent", l.a)
+		}
+	}
+	return l
+}
+
+func (l *link) Run() {
+	if l.gate.Session && !l.handshake() {
+		return
+	}
+	l.startHeartbeat()
+
 	for {
-		data, err := a.conn.ReadMsg()
+		data, err := l.read()
 		if err != nil {
 			log.Debugf("read message: %v", err)
 			break
 		}
 
-		if a.gate.Processor != nil {
-			msg, err := a.gate.Processor.Unmarshal(data)
-			if err != nil {
-				log.Debugf("unmarshal message error: %v", err)
-				break
-			}
-			// if conf.RunMode == "debug" {
-			// 	log.Debugf("receive msg = %s\n", string(data))
-			// }
-			err = a.gate.Processor.Route(msg, a)
-			if err != nil {
-				log.Debugf("route message error: %v", err)
-				break
-			}
+		ok := l.handle(data)
+		l.release(data)
+		if !ok {
+			break
 		}
 	}
 }
 
-func (a *agent) OnClose() {
+// false to close the connection
+func (l *link) handle(data []byte) bool {
+	// every frame read is charged, the session control frames included
+	route, ok := l.allow(l.connBucket(), l.gate.RateLimit)
+	if !ok || !route {
+		return ok
+	}
+
+	if l.gate.Session {
+		var err error
+		data, err = l.frame(data)
+		if err != nil {
+			log.Debugf("read frame: %v", err)
+			return false
+		}
+		if data == nil {
+			return true
+		}
+	}
+
+	l.a.record(Inbound, data)
+
+	if l.gate.Processor == nil {
+		return true
+	}
+	msg, err := l.unmarshal(data)
+	if err != nil {
+		log.Debugf("unmarshal message error: %v", err)
+		return false
+	}
+	if l.isHeartbeat(msg) {
+		return true
+	}
+	if !l.authorized(msg) {
+		log.Debugf("close agent %v: message %v before auth", l.a.id, reflect.TypeOf(msg))
+		return false
+	}
+	route, ok = l.allow(l.msgBucket(reflect.TypeOf(msg)))
+	if !ok || !route {
+		return ok
+	}
+	l.gate.trace(l.a, Inbound, msg, [][]byte{data})
+	err = l.gate.Processor.Route(msg, l.a)
+	if err != nil {
+		log.Debugf("route message error: %v", err)
+		return false
+	}
+	return true
+}
+
+func (l *link) unmarshal(data []byte) (interface{}, error) {
+	if l.releaser != nil {
+		return l.gate.Processor.(network.BorrowProcessor).UnmarshalBorrowed(data)
+	}
+	return l.gate.Processor.Unmarshal(data)
+}
+
+// the message read is given back to the pool
+func (l *link) release(data []byte) {
+	if l.releaser != nil {
+		l.releaser.ReleaseMsg(data)
+	}
+}
+
+func (l *link) OnClose() {
+	l.stopHeartbeat()
+	if l.a == nil {
+		return
+	}
+	if l.gate.Session {
+		l.a.detach(l.conn)
+	} else {
+		l.a.finish()
+	}
+}
+
+type agent struct {
+	sync.Mutex
+	conn     network.Conn
+	gate     *Gate
+	id       uint64
+	attached bool
+	closed   bool
+	finished bool
+	userData interface{}
+	userID   interface{}
+	groups   map[string]struct{}
+	login    *time.Timer
+	recorder *Recorder
+	// unix nano, atomic
+	lastWrite int64
+
+	// session
+	token   [tokenLen]byte
+	seq     uint32
+	recvSeq uint32
+	pending []pendingMsg
+	// the next DATA of a running replay, 0 if none
+	replay      uint32
+	replayTimer *time.Timer
+	replayGen   uint32
+	keys        map[string]struct{}
+	keyRing     []string
+	expire      *time.Timer
+}
+
+func (a *agent) finish() {
+	a.Lock()
+	if a.finished {
+		a.Unlock()
+		return
+	}
+	a.finished = true
+	a.closed = true
+	a.pending = nil
+	a.stopReplay()
+	if a.login != nil {
+		a.login.Stop()
+	}
+	a.Unlock()
+
+	if a.recorder != nil {
+		a.recorder.Close()
+	}
+
+	a.gate.removeAgent(a)
 	if a.gate.AgentChanRPC != nil {
 		err := a.gate.AgentChanRPC.Call0("CloseAgent", a)
 		if err != nil {
@@ -130,6 +449,38 @@ func (a *agent) OnClose() {
 	}
 }
 
+// the session is over, a detached one is finished at once
+func (a *agent) close(destroy bool) {
+	a.Lock()
+	if a.closed {
+		a.Unlock()
+		return
+	}
+	a.closed = true
+
+	if a.attached {
+		if destroy {
+			a.conn.Destroy()
+		} else {
+			a.conn.Close()
+		}
+		a.Unlock()
+		return
+	}
+
+	if a.expire != nil {
+		a.expire.Stop()
+	}
+	a.Unlock()
+
+	// may be called by the AgentChanRPC goroutine
+	go a.finish()
+}
+
+func (a *agent) ID() uint64 {
+	return a.id
+}
+
 func (a *agent) WriteMsg(msg interface{}) {
 	if a.gate.Processor != nil {
 		data, err := a.gate.Processor.Marshal(msg)
@@ -137,54 +488,43 @@ func (a *agent) WriteMsg(msg interface{}) {
 			log.Errorf("marshal message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
-		// if conf.RunMode == "debug" {
-		// 	log.Debugf("send msg, id = %s, data = %s\n", string(data[0]), string(data[1]))
-		// }
-		err = a.conn.WriteMsg(data...)
+		err = a.write(data)
 		if err != nil {
 			log.Errorf("write message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
-		a.log(msg)
+		a.gate.trace(a, Outbound, msg, data)
 	}
 }
 
-func (a *agent) log(m interface{}) {
-	// roleID := ""
-	// userData := a.UserData()
-	// if userData != nil {
-	// 	r := userData.(*data.Role)
-	// 	roleID = r.ID.Hex()
-	// }
-	t := reflect.TypeOf(m).Elem()
-	if t.Name() != "S2C_SystemTime" {
-		v := reflect.ValueOf(m).Elem()
-		key := ""
-		var tmp []string
-		values := ""
-		for i := 0; i < t.NumField(); i++ {
-			tmp = strings.Split(t.Field(i).Tag.Get("sproto"), ",")
-			key = strings.Split(tmp[len(tmp)-1], "=")[1]
-			values += fmt.Sprintf("key = %s val = %v,", key, v.FieldByName(t.Field(i).Name))
-		}
-		log.Debugf(fmt.Sprintf("[send msg]msg=%s,%s", t.Name(), values))
+// data must not be modified by the others goroutines
+func (a *agent) write(data [][]byte) error {
+	atomic.StoreInt64(&a.lastWrite, time.Now().UnixNano())
+	a.record(Outbound, data...)
+	if a.gate.Session {
+		return a.writeData(data)
 	}
+	return a.conn.WriteMsg(data...)
 }
 
 func (a *agent) LocalAddr() net.Addr {
+	a.Lock()
+	defer a.Unlock()
 	return a.conn.LocalAddr()
 }
 
 func (a *agent) RemoteAddr() net.Addr {
+	a.Lock()
+	defer a.Unlock()
 	return a.conn.RemoteAddr()
 }
 
 func (a *agent) Close() {
-	a.conn.Close()
+	a.close(false)
 }
 
 func (a *agent) Destroy() {
-	a.conn.Destroy()
+	a.close(true)
 }
 
 func (a *agent) UserData() interface{} {
@@ -0,0 +1,178 @@
+package gate
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/hongjie104/leaf/log"
+)
+
+// bind the user to the agent, the agent previously bound to the user is
+// returned, nil if none
+// goroutine safe
+func (a *agent) BindUser(userID interface{}) Agent {
+	gate := a.gate
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	if a.userID != nil && gate.users[a.userID] == a {
+		delete(gate.users, a.userID)
+	}
+	a.userID = userID
+	if userID == nil || gate.agents[a.id] != a {
+		return nil
+	}
+
+	old := gate.users[userID]
+	gate.users[userID] = a
+	if old == nil || old == a {
+		return nil
+	}
+	old.userID = nil
+	return old
+}
+
+// goroutine safe
+func (a *agent) UserID() interface{} {
+	a.gate.mutexAgents.Lock()
+	defer a.gate.mutexAgents.Unlock()
+	return a.userID
+}
+
+// goroutine safe
+func (gate *Gate) AgentByUser(userID interface{}) Agent {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	if a, ok := gate.users[userID]; ok {
+		return a
+	}
+	return nil
+}
+
+// a closed agent leaves its groups
+// goroutine safe
+func (gate *Gate) Join(group string, a Agent) {
+	_a := a.(*agent)
+
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	if gate.agents[_a.id] != _a {
+		return
+	}
+	members, ok := gate.groups[group]
+	if !ok {
+		members = make(map[*agent]struct{})
+		gate.groups[group] = members
+	}
+	members[_a] = struct{}{}
+	if _a.groups == nil {
+		_a.groups = make(map[string]struct{})
+	}
+	_a.groups[group] = struct{}{}
+}
+
+// goroutine safe
+func (gate *Gate) Leave(group string, a Agent) {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	gate.leave(group, a.(*agent))
+}
+
+// goroutine not safe, the caller holds mutexAgents
+func (gate *Gate) leave(group string, a *agent) {
+	delete(a.groups, group)
+	members, ok := gate.groups[group]
+	if !ok {
+		return
+	}
+	delete(members, a)
+	if len(members) == 0 {
+		delete(gate.groups, group)
+	}
+}
+
+// the groups of the agent, sorted
+// goroutine safe
+func (gate *Gate) Groups(a Agent) []string {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	var groups []string
+	for group := range a.(*agent).groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// goroutine safe
+func (gate *Gate) Members(group string) []Agent {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	members := make([]Agent, 0, len(gate.groups[group]))
+	for a := range gate.groups[group] {
+		members = append(members, a)
+	}
+	return members
+}
+
+// send the message to all the agents, it is marshaled once
+// goroutine safe
+func (gate *Gate) Broadcast(msg interface{}) {
+	gate.mutexAgents.Lock()
+	agents := make([]*agent, 0, len(gate.agents))
+	for _, a := range gate.agents {
+		agents = append(agents, a)
+	}
+	gate.mutexAgents.Unlock()
+
+	gate.fanOut(agents, msg)
+}
+
+// send the message to the members of the group, it is marshaled once
+// goroutine safe
+func (gate *Gate) Multicast(group string, msg interface{}) {
+	gate.mutexAgents.Lock()
+	agents := make([]*agent, 0, len(gate.groups[group]))
+	for a := range gate.groups[group] {
+		agents = append(agents, a)
+	}
+	gate.mutexAgents.Unlock()
+
+	gate.fanOut(agents, msg)
+}
+
+// send the message to the agents, it is marshaled once
+// goroutine safe
+func (gate *Gate) SendTo(agents []Agent, msg interface{}) {
+	_agents := make([]*agent, len(agents))
+	for i, a := range agents {
+		_agents[i] = a.(*agent)
+	}
+	gate.fanOut(_agents, msg)
+}
+
+func (gate *Gate) fanOut(agents []*agent, msg interface{}) {
+	if gate.Processor == nil || len(agents) == 0 {
+		return
+	}
+
+	// shared by the connections, never modified
+	data, err := gate.Processor.Marshal(msg)
+	if err != nil {
+		log.Errorf("marshal message %v error: %v", reflect.TypeOf(msg), err)
+		return
+	}
+
+	for _, a := range agents {
+		if err := a.write(data); err != nil {
+			log.Debugf("write message %v to %v error: %v", reflect.TypeOf(msg), a.id, err)
+			continue
+		}
+		gate.trace(a, Outbound, msg, data)
+	}
+}
@@ -0,0 +1,70 @@
+package gate
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	gate := startGate(t, new(Gate))
+	defer gate.stop()
+
+	var clients []*testClient
+	var agents []UserAgent
+	for i := 0; i < 3; i++ {
+		c := dial(t, gate.TCPAddr)
+		defer c.conn.Close()
+		clients = append(clients, c)
+		agents = append(agents, <-gate.newAgent)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID() < agents[j].ID()
+	})
+
+	// users
+	if old := agents[0].BindUser("alice"); old != nil {
+		t.Fatalf("bound to %v", old.(UserAgent).ID())
+	}
+	if old := agents[1].BindUser("alice"); old != agents[0] {
+		t.Fatal("previous agent of alice not returned")
+	}
+	if gate.AgentByUser("alice") != agents[1] || agents[0].UserID() != nil {
+		t.Fatal("alice not bound to the second agent")
+	}
+
+	// groups
+	gate.Join("guild", agents[0])
+	gate.Join("guild", agents[1])
+	gate.Join("team", agents[1])
+	gate.Leave("team", agents[1])
+	if groups := gate.Groups(agents[1]); len(groups) != 1 || groups[0] != "guild" {
+		t.Fatalf("groups %v, want [guild]", groups)
+	}
+
+	gate.Multicast("guild", &Text{"guild"})
+	gate.Broadcast(&Text{"all"})
+	gate.SendTo([]Agent{agents[2]}, &Text{"third"})
+
+	want := [][]string{{"guild", "all"}, {"guild", "all"}, {"all", "third"}}
+	for i, a := range agents {
+		// the clients are connected in order
+		c := clients[a.ID()-agents[0].ID()]
+		for _, text := range want[i] {
+			if got := c.parseText(c.readMsg()); got != text {
+				t.Fatalf("agent %v received %v, want %v", a.ID(), got, text)
+			}
+		}
+	}
+
+	// closed agents leave
+	agents[1].Close()
+	if closed := <-gate.closeAgent; closed != agents[1] {
+		t.Fatalf("agent %v closed", closed.ID())
+	}
+	if members := gate.Members("guild"); len(members) != 1 || members[0] != agents[0] {
+		t.Fatalf("%v members left", len(members))
+	}
+	if gate.AgentByUser("alice") != nil {
+		t.Fatal("alice still bound")
+	}
+}
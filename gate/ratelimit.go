@@ -0,0 +1,141 @@
+package gate
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/util"
+)
+
+// what to do with a message over the limit
+type RateAction int
+
+const (
+	// drop the message
+	RateDrop RateAction = iota
+	// hold the connection until the message is allowed
+	RateDelay
+	// drop the message and send RateWarnMsg to the client
+	RateWarn
+	// close the connection
+	RateDisconnect
+)
+
+// messages per second, a zero Rate is no limit
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Action RateAction
+}
+
+// the counters of the rate limits
+type RateStats struct {
+	Dropped      uint64
+	Delayed      uint64
+	Warned       uint64
+	Disconnected uint64
+	// connections refused by AcceptRate
+	Rejected uint64
+}
+
+type rateStats struct {
+	dropped      uint64
+	delayed      uint64
+	warned       uint64
+	disconnected uint64
+}
+
+// limit the messages of the type msg per connection
+// you must call the function before calling Run
+func (gate *Gate) SetMsgRateLimit(msg interface{}, limit RateLimit) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("message pointer required")
+	}
+	if gate.msgRateLimits == nil {
+		gate.msgRateLimits = make(map[reflect.Type]RateLimit)
+	}
+	gate.msgRateLimits[msgType] = limit
+}
+
+// goroutine safe
+func (gate *Gate) RateStats() RateStats {
+	st := RateStats{
+		Dropped:      atomic.LoadUint64(&gate.rateStats.dropped),
+		Delayed:      atomic.LoadUint64(&gate.rateStats.delayed),
+		Warned:       atomic.LoadUint64(&gate.rateStats.warned),
+		Disconnected: atomic.LoadUint64(&gate.rateStats.disconnected),
+	}
+	gate.mutexAgents.Lock()
+	if gate.tcpServer != nil {
+		st.Rejected += gate.tcpServer.Rejected()
+	}
+	if gate.wsServer != nil {
+		st.Rejected += gate.wsServer.Rejected()
+	}
+	gate.mutexAgents.Unlock()
+	return st
+}
+
+// the bucket of the connection, nil if there is no limit
+func (l *link) connBucket() *util.TokenBucket {
+	if l.rateLimit == nil && l.gate.RateLimit.Rate > 0 {
+		l.rateLimit = util.NewTokenBucket(l.gate.RateLimit.Rate, l.gate.RateLimit.Burst)
+	}
+	return l.rateLimit
+}
+
+// the bucket of the message type on the connection, nil if there is no limit
+func (l *link) msgBucket(msgType reflect.Type) (*util.TokenBucket, RateLimit) {
+	limit, ok := l.gate.msgRateLimits[msgType]
+	if !ok || limit.Rate <= 0 {
+		return nil, limit
+	}
+	if l.msgRateLimits == nil {
+		l.msgRateLimits = make(map[reflect.Type]*util.TokenBucket)
+	}
+	b, ok := l.msgRateLimits[msgType]
+	if !ok {
+		b = util.NewTokenBucket(limit.Rate, limit.Burst)
+		l.msgRateLimits[msgType] = b
+	}
+	return b, limit
+}
+
+// route is false if the message is dropped, ok is false if the connection
+// must be closed
+func (l *link) allow(b *util.TokenBucket, limit RateLimit) (route bool, ok bool) {
+	if b == nil {
+		return true, true
+	}
+
+	st := &l.gate.rateStats
+	if limit.Action == RateDelay {
+		if d := b.Reserve(); d > 0 {
+			atomic.AddUint64(&st.delayed, 1)
+			time.Sleep(d)
+		}
+		return true, true
+	}
+	if b.Allow() {
+		return true, true
+	}
+
+	switch limit.Action {
+	case RateWarn:
+		atomic.AddUint64(&st.warned, 1)
+		if l.gate.RateWarnMsg != nil && l.a != nil {
+			l.a.WriteMsg(l.gate.RateWarnMsg)
+		}
+		return false, true
+	case RateDisconnect:
+		atomic.AddUint64(&st.disconnected, 1)
+		log.Debugf("close conn %v: rate limit exceeded", l.conn.RemoteAddr())
+		return false, false
+	default:
+		atomic.AddUint64(&st.dropped, 1)
+		return false, true
+	}
+}
@@ -0,0 +1,109 @@
+package gate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func (c *testClient) writeMsg(text string) {
+	c.writeJSON(map[string]Text{"Text": {text}})
+}
+
+func (c *testClient) writeJSON(m interface{}) {
+	data, _ := json.Marshal(m)
+	b := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(b, uint16(len(data)))
+	copy(b[2:], data)
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	g := &Gate{
+		RateLimit:   RateLimit{Rate: 0.1, Burst: 2, Action: RateWarn},
+		RateWarnMsg: &Text{"slow down"},
+	}
+	g.SetMsgRateLimit(&Text{}, RateLimit{Rate: 0.1, Burst: 1, Action: RateDisconnect})
+	gate := startGate(t, g)
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	<-gate.newAgent
+
+	// the first passes both limits, the second the connection limit only
+	c.writeMsg("1")
+	c.writeMsg("2")
+	if got := <-gate.received; got != "1" {
+		t.Fatalf("received %v, want 1", got)
+	}
+	select {
+	case <-gate.closeAgent:
+	case <-time.After(time.Second):
+		t.Fatal("not disconnected")
+	}
+
+	st := gate.RateStats()
+	if st.Disconnected != 1 || st.Warned != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestRateLimitWarn(t *testing.T) {
+	gate := startGate(t, &Gate{
+		RateLimit:   RateLimit{Rate: 0.1, Burst: 2, Action: RateWarn},
+		RateWarnMsg: &Text{"slow down"},
+	})
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	<-gate.newAgent
+
+	// the third is over the connection limit
+	c.writeMsg("1")
+	c.writeMsg("2")
+	c.writeMsg("3")
+	if got := c.parseText(c.readMsg()); got != "slow down" {
+		t.Fatalf("got %v, want the warning", got)
+	}
+	if st := gate.RateStats(); st.Warned != 1 || st.Disconnected != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestRateLimitSessionFrames(t *testing.T) {
+	gate := startGate(t, &Gate{
+		Session:   true,
+		RateLimit: RateLimit{Rate: 0.1, Burst: 3, Action: RateDisconnect},
+	})
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	c.write(frameHello, 0, 0, nil)
+	c.read()
+	a := <-gate.newAgent
+	a.WriteMsg(&Text{"1"})
+	c.readText()
+
+	// each NACK asks for a retransmit, no message reaches the processor
+	for i := 0; i < 10; i++ {
+		c.write(frameNack, 0, 1, nil)
+	}
+	// the session is detached, the retransmits read until the close
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.Copy(ioutil.Discard, c.conn)
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		t.Fatal("not disconnected")
+	}
+	if st := gate.RateStats(); st.Disconnected != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
@@ -0,0 +1,332 @@
+package gate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/network"
+)
+
+// a recording holds the messages of the Processor of a session, the
+// session frames are not recorded
+// ---------------------------------
+// | magic | start | record ...    |
+// ---------------------------------
+// |   8   |   8   |               |
+//
+// start is in unix nanoseconds, each record is
+// -----------------------------------
+// | dir | delay   | len     | data  |
+// -----------------------------------
+// |  1  | uvarint | uvarint |       |
+//
+// delay is in microseconds since the previous record
+var recordMagic = [8]byte{'L', 'E', 'A', 'F', 'R', 'E', 'C', 1}
+
+// the records are written once recordFlushSize bytes are buffered, or
+// recordFlushInterval after the first record buffered
+const (
+	recordFlushSize     = 32 * 1024
+	recordFlushInterval = time.Second
+)
+
+type Record struct {
+	Time time.Time
+	Dir  Direction
+	Data []byte
+}
+
+// goroutine safe
+type Recorder struct {
+	mutex sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	flush *time.Timer
+	last  time.Time
+	err   error
+}
+
+// the file must not exist, a recording is never overwritten
+func NewRecorder(name string) (*Recorder, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(Recorder)
+	r.file = file
+	r.w = bufio.NewWriterSize(file, recordFlushSize)
+	r.last = time.Now()
+
+	var start [8]byte
+	binary.BigEndian.PutUint64(start[:], uint64(r.last.UnixNano()))
+	r.w.Write(recordMagic[:])
+	r.w.Write(start[:])
+	r.err = r.w.Flush()
+	return r, r.err
+}
+
+func (r *Recorder) Record(dir Direction, data ...[]byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+
+	now := time.Now()
+	var delay uint64
+	if now.After(r.last) {
+		delay = uint64(now.Sub(r.last) / time.Microsecond)
+		r.last = r.last.Add(time.Duration(delay) * time.Microsecond)
+	}
+
+	var msgLen int
+	for _, b := range data {
+		msgLen += len(b)
+	}
+
+	var head [1 + 2*binary.MaxVarintLen64]byte
+	head[0] = byte(dir)
+	n := 1
+	n += binary.PutUvarint(head[n:], delay)
+	n += binary.PutUvarint(head[n:], uint64(msgLen))
+	r.w.Write(head[:n])
+	for _, b := range data {
+		_, r.err = r.w.Write(b)
+	}
+	if r.flush == nil && r.w.Buffered() > 0 {
+		r.flush = time.AfterFunc(recordFlushInterval, r.flushLater)
+	}
+	return r.err
+}
+
+func (r *Recorder) flushLater() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.flush = nil
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+}
+
+// the file is synced before closed
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.flush != nil {
+		r.flush.Stop()
+		r.flush = nil
+	}
+	err := r.w.Flush()
+	if serr := r.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	if r.err == nil {
+		r.err = errors.New("recorder closed")
+	}
+	return err
+}
+
+// a recording per agent in RecordDir, named after the start and the agent id,
+// the ids restart with the gate, a suffix is added if the name is taken
+func (gate *Gate) newRecorder(a *agent) *Recorder {
+	base := filepath.Join(gate.RecordDir, fmt.Sprintf("%v-%v", time.Now().Format("20060102-150405"), a.id))
+	name := base + ".rec"
+	for i := 1; ; i++ {
+		r, err := NewRecorder(name)
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%v-%v.rec", base, i)
+			continue
+		}
+		if err != nil {
+			log.Errorf("record agent %v error: %v", a.id, err)
+			return nil
+		}
+		return r
+	}
+}
+
+func (a *agent) record(dir Direction, data ...[]byte) {
+	if a.recorder == nil {
+		return
+	}
+	if err := a.recorder.Record(dir, data...); err != nil {
+		log.Debugf("record agent %v error: %v", a.id, err)
+	}
+}
+
+type RecordReader struct {
+	file  *os.File
+	r     *bufio.Reader
+	start time.Time
+	last  time.Time
+}
+
+func OpenRecord(name string) (*RecordReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rr := new(RecordReader)
+	rr.file = file
+	rr.r = bufio.NewReader(file)
+
+	var head [16]byte
+	if _, err := io.ReadFull(rr.r, head[:]); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if string(head[:8]) != string(recordMagic[:]) {
+		file.Close()
+		return nil, errors.New("invalid recording")
+	}
+	rr.start = time.Unix(0, int64(binary.BigEndian.Uint64(head[8:])))
+	rr.last = rr.start
+	return rr, nil
+}
+
+func (rr *RecordReader) Start() time.Time {
+	return rr.start
+}
+
+// io.EOF after the last record
+func (rr *RecordReader) Next() (*Record, error) {
+	dir, err := rr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	delay, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	msgLen, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if msgLen > 1<<24 {
+		return nil, errors.New("record too long")
+	}
+	data := make([]byte, msgLen)
+	if _, err := io.ReadFull(rr.r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	rr.last = rr.last.Add(time.Duration(delay) * time.Microsecond)
+	return &Record{Time: rr.last, Dir: Direction(dir), Data: data}, nil
+}
+
+func (rr *RecordReader) Close() error {
+	return rr.file.Close()
+}
+
+// send the inbound messages of the recording, speed 2 replays twice as
+// fast, speed 0 as fast as possible
+func Replay(rr *RecordReader, speed float64, send func(data []byte) error) error {
+	begin := time.Now()
+	for {
+		r, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.Dir != Inbound {
+			continue
+		}
+
+		if speed > 0 {
+			at := begin.Add(time.Duration(float64(r.Time.Sub(rr.start)) / speed))
+			if d := time.Until(at); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		if err := send(r.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// route the inbound messages of the recording as if sent by userData, for a
+// test harness
+func ReplayProcessor(rr *RecordReader, speed float64, processor network.Processor, userData interface{}) error {
+	return Replay(rr, speed, func(data []byte) error {
+		msg, err := processor.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+		return processor.Route(msg, userData)
+	})
+}
+
+// write the inbound messages of the recording to a connection to a running
+// server, the connection of a network.TCPClient for example
+// the messages are not framed, see ReplaySessionConn for a gate in Session
+// mode
+func ReplayConn(rr *RecordReader, speed float64, conn network.Conn) error {
+	return Replay(rr, speed, func(data []byte) error {
+		return conn.WriteMsg(data)
+	})
+}
+
+// ReplayConn to a gate in Session mode, a new session is opened and the
+// messages are sent in DATA frames
+// conn is read until closed to acknowledge the DATA frames of the gate, it
+// must not be read by the caller
+func ReplaySessionConn(rr *RecordReader, speed float64, conn network.Conn) error {
+	if err := conn.WriteMsg(frameHeader(frameHello, 0, 0)); err != nil {
+		return err
+	}
+	data, err := conn.ReadMsg()
+	if err != nil {
+		return err
+	}
+	typ, _, _, _, err := parseFrame(data)
+	releaseMsg(conn, data)
+	if err != nil {
+		return err
+	}
+	if typ != frameWelcome {
+		return fmt.Errorf("unexpected frame %v", typ)
+	}
+
+	go func() {
+		for {
+			data, err := conn.ReadMsg()
+			if err != nil {
+				return
+			}
+			typ, _, seq, _, err := parseFrame(data)
+			releaseMsg(conn, data)
+			if err == nil && typ == frameData {
+				conn.WriteMsg(frameHeader(frameAck, 0, seq))
+			}
+		}
+	}()
+
+	var seq uint32
+	return Replay(rr, speed, func(data []byte) error {
+		seq++
+		return conn.WriteMsg(frameHeader(frameData, 0, seq), data)
+	})
+}
+
+func releaseMsg(conn network.Conn, data []byte) {
+	if r, ok := conn.(network.MsgReleaser); ok {
+		r.ReleaseMsg(data)
+	}
+}
@@ -0,0 +1,242 @@
+package gate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/network"
+)
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+	gate := startGate(t, &Gate{RecordDir: dir})
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	a := <-gate.newAgent
+	c.writeMsg("ping")
+	<-gate.received
+	a.WriteMsg(&Text{"pong"})
+	c.readMsg()
+	c.conn.Close()
+	<-gate.closeAgent
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.rec"))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("recordings %v: %v", names, err)
+	}
+	rr, err := OpenRecord(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []Direction
+	var texts []string
+	for {
+		r, err := rr.Next()
+		if err != nil {
+			break
+		}
+		if r.Time.Before(rr.Start()) {
+			t.Fatalf("record at %v before %v", r.Time, rr.Start())
+		}
+		dirs = append(dirs, r.Dir)
+		if r.Dir == Inbound {
+			texts = append(texts, string(r.Data))
+		} else {
+			texts = append(texts, c.parseText(r.Data))
+		}
+	}
+	rr.Close()
+	if len(dirs) != 2 || dirs[0] != Inbound || texts[0] != `{"Text":{"Text":"ping"}}` || dirs[1] != Outbound || texts[1] != "pong" {
+		t.Fatalf("records %v %q", dirs, texts)
+	}
+
+	// the inbound messages only
+	rr, err = OpenRecord(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	if err := ReplayProcessor(rr, 0, gate.Processor, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-gate.received; got != "ping" {
+		t.Fatalf("replayed %v, want ping", got)
+	}
+	select {
+	case got := <-gate.received:
+		t.Fatalf("replayed %v", got)
+	default:
+	}
+}
+
+func TestRecorderFlush(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.rec")
+	r, err := NewRecorder(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := func() int64 {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	if n := size(); n != 16 {
+		t.Fatalf("size %v, want the header only", n)
+	}
+
+	// flushed after recordFlushInterval
+	r.Record(Inbound, []byte("ping"))
+	if n := size(); n != 16 {
+		t.Fatalf("size %v, flushed at once", n)
+	}
+	for deadline := time.Now().Add(5 * recordFlushInterval); size() == 16; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("not flushed")
+		}
+	}
+
+	// flushed once recordFlushSize is buffered
+	n := size()
+	r.Record(Outbound, make([]byte, recordFlushSize))
+	if size() == n {
+		t.Fatal("not flushed")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Record(Inbound, []byte("ping")) == nil {
+		t.Fatal("recorded after close")
+	}
+
+	rr, err := OpenRecord(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	for _, want := range []int{4, recordFlushSize} {
+		rec, err := rr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.Data) != want {
+			t.Fatalf("record %v bytes, want %v", len(rec.Data), want)
+		}
+	}
+}
+
+func TestRecordName(t *testing.T) {
+	dir := t.TempDir()
+	gate := startGate(t, &Gate{RecordDir: dir})
+	defer gate.stop()
+
+	// left by a gate restarted within the second
+	now := time.Now()
+	var old []string
+	for _, at := range []time.Time{now, now.Add(time.Second)} {
+		name := filepath.Join(dir, at.Format("20060102-150405")+"-1.rec")
+		if err := ioutil.WriteFile(name, []byte("old"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		old = append(old, name)
+	}
+	if _, err := NewRecorder(old[0]); !os.IsExist(err) {
+		t.Fatalf("NewRecorder: %v, want exist", err)
+	}
+
+	c := dial(t, gate.TCPAddr)
+	<-gate.newAgent
+	c.conn.Close()
+	<-gate.closeAgent
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.rec"))
+	if err != nil || len(names) != 3 {
+		t.Fatalf("recordings %v: %v", names, err)
+	}
+	for _, name := range old {
+		if b, err := ioutil.ReadFile(name); err != nil || string(b) != "old" {
+			t.Fatalf("%v overwritten: %q %v", name, b, err)
+		}
+	}
+}
+
+type replayAgent struct {
+	conn *network.TCPConn
+	rr   *RecordReader
+	done chan error
+	stop chan struct{}
+}
+
+// the connection is closed once Run returns
+func (ra *replayAgent) Run() {
+	ra.done <- ReplaySessionConn(ra.rr, 0, ra.conn)
+	<-ra.stop
+}
+
+func (ra *replayAgent) OnClose() {}
+
+func TestReplaySession(t *testing.T) {
+	gate := startGate(t, &Gate{Session: true, MaxPendingMsg: 2})
+	defer gate.stop()
+
+	name := filepath.Join(t.TempDir(), "test.rec")
+	r, err := NewRecorder(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"a", "b"} {
+		data, _ := json.Marshal(map[string]Text{"Text": {text}})
+		r.Record(Inbound, data)
+		r.Record(Outbound, []byte("ignored"))
+	}
+	r.Close()
+	rr, err := OpenRecord(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+
+	done := make(chan error, 1)
+	stop := make(chan struct{})
+	client := &network.TCPClient{
+		Addr: gate.TCPAddr,
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			return &replayAgent{conn, rr, done, stop}
+		},
+	}
+	client.Start()
+	defer client.Close()
+	defer close(stop)
+
+	a := (<-gate.newAgent).(*agent)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a", "b"} {
+		if got := <-gate.received; got != want {
+			t.Fatalf("replayed %v, want %v", got, want)
+		}
+	}
+
+	// more than MaxPendingMsg, the messages of the gate are acknowledged
+	for i := 0; i < 5; i++ {
+		a.WriteMsg(&Text{"x"})
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			a.Lock()
+			n := len(a.pending)
+			a.Unlock()
+			if n == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("not acknowledged")
+			}
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package gate
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/network"
+)
+
+// in session mode, each message starts with a frame header
+// ---------------------------------
+// | type | flags | seq | payload |
+// ---------------------------------
+// |  1   |   1   |  4  |         |
+//
+// HELLO, from the client as the first message of a connection, the payload
+// is empty for a new session or id (8) + token (16) to resume one, seq is
+// the last DATA received by the client
+// WELCOME, the reply to HELLO, the payload is id (8) + token (16), flags
+// has flagResumed if the session is resumed, seq is the last DATA received
+// by the server
+// DATA, a message of the Processor, seq is numbered from 1 by each side, a
+// DATA of the client with seq 0 is not sequenced
+// with flagIdempotent, the payload of the client starts with a key, len (1)
+// + key, and a second message with the same key is dropped
+// ACK, seq is the last DATA received, each sequenced DATA of the client is
+// acknowledged
+// NACK, seq is the first DATA missing, the DATA from seq are sent again
+const (
+	frameHello   = 0x01
+	frameWelcome = 0x02
+	frameData    = 0x03
+	frameAck     = 0x04
+	frameNack    = 0x05
+)
+
+const (
+	flagResumed    = 0x01
+	flagIdempotent = 0x02
+)
+
+const (
+	headerLen = 6
+	tokenLen  = 16
+)
+
+// a replay fills at most half of the free room of the write queue, the rest
+// is left for the other frames, and goes on after replayDelay
+const (
+	replayBatch = 64
+	replayDelay = 10 * time.Millisecond
+)
+
+type pendingMsg struct {
+	seq  uint32
+	data [][]byte
+}
+
+func frameHeader(typ byte, flags byte, seq uint32) []byte {
+	h := make([]byte, headerLen)
+	h[0] = typ
+	h[1] = flags
+	binary.BigEndian.PutUint32(h[2:], seq)
+	return h
+}
+
+func parseFrame(data []byte) (typ byte, flags byte, seq uint32, payload []byte, err error) {
+	if len(data) < headerLen {
+		err = errors.New("frame too short")
+		return
+	}
+	typ = data[0]
+	flags = data[1]
+	seq = binary.BigEndian.Uint32(data[2:])
+	payload = data[headerLen:]
+	return
+}
+
+// a resumed session keeps its agent, NewAgent is only sent for a new one
+func (l *link) handshake() bool {
+	data, err := l.read()
+	if err != nil {
+		log.Debugf("read message: %v", err)
+		return false
+	}
+	defer l.release(data)
+	typ, _, ack, payload, err := parseFrame(data)
+	if err != nil || typ != frameHello {
+		log.Debugf("invalid hello from %v", l.conn.RemoteAddr())
+		return false
+	}
+
+	switch len(payload) {
+	case 0:
+	case 8 + tokenLen:
+		id := binary.BigEndian.Uint64(payload)
+		if a := l.gate.getAgent(id); a != nil && a.resume(l.conn, payload[8:], ack) {
+			l.a = a
+			return true
+		}
+		log.Debugf("session %v not resumed", id)
+	default:
+		log.Debugf("invalid hello from %v", l.conn.RemoteAddr())
+		return false
+	}
+
+	l.a = l.gate.newAgent(l.conn)
+	l.a.Lock()
+	l.a.welcome(0)
+	l.a.Unlock()
+	if l.gate.AgentChanRPC != nil {
+		l.gate.AgentChanRPC.Go("NewAgent", l.a)
+	}
+	return true
+}
+
+// the payload of a DATA frame, nil for the other frames
+func (l *link) frame(data []byte) ([]byte, error) {
+	typ, flags, seq, payload, err := parseFrame(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case frameData:
+		if seq != 0 && !l.a.receive(seq) {
+			return nil, nil
+		}
+		if flags&flagIdempotent != 0 {
+			if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+				return nil, errors.New("invalid idempotent key")
+			}
+			key := string(payload[1 : 1+payload[0]])
+			if l.a.duplicate(key) {
+				log.Debugf("session %v: duplicate message %v", l.a.id, key)
+				return nil, nil
+			}
+			payload = payload[1+payload[0]:]
+		}
+		return payload, nil
+	case frameAck:
+		l.a.ack(seq)
+		return nil, nil
+	case frameNack:
+		l.a.retransmit(seq)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected frame %v", typ)
+	}
+}
+
+// goroutine not safe, the caller holds the lock
+func (a *agent) welcome(flags byte) {
+	payload := make([]byte, 8+tokenLen)
+	binary.BigEndian.PutUint64(payload, a.id)
+	copy(payload[8:], a.token[:])
+	a.conn.WriteMsg(frameHeader(frameWelcome, flags, a.recvSeq), payload)
+}
+
+func (a *agent) resume(conn network.Conn, token []byte, ack uint32) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.closed || subtle.ConstantTimeCompare(token, a.token[:]) != 1 {
+		return false
+	}
+
+	// the old connection may be half-open
+	if a.attached {
+		a.conn.Close()
+	}
+	if a.expire != nil {
+		a.expire.Stop()
+		a.expire = nil
+	}
+	a.conn = conn
+	a.attached = true
+	a.stopReplay()
+
+	a.welcome(flagResumed)
+	a.resend(ack + 1)
+	return true
+}
+
+// the session waits SessionTimeout for a resume
+func (a *agent) detach(conn network.Conn) {
+	a.Lock()
+	if !a.attached || a.conn != conn {
+		a.Unlock()
+		return
+	}
+	a.attached = false
+	a.stopReplay()
+	if a.closed {
+		a.Unlock()
+		a.finish()
+		return
+	}
+	a.expire = time.AfterFunc(a.gate.SessionTimeout, a.timeout)
+	a.Unlock()
+}
+
+func (a *agent) timeout() {
+	a.Lock()
+	if a.attached || a.closed {
+		a.Unlock()
+		return
+	}
+	a.closed = true
+	a.Unlock()
+
+	log.Debugf("session %v expired", a.id)
+	a.finish()
+}
+
+// false if the message is a duplicate or comes after a gap
+func (a *agent) receive(seq uint32) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	switch {
+	case seq <= a.recvSeq:
+		a.conn.WriteMsg(frameHeader(frameAck, 0, a.recvSeq))
+		return false
+	case seq > a.recvSeq+1:
+		a.conn.WriteMsg(frameHeader(frameNack, 0, a.recvSeq+1))
+		return false
+	}
+
+	a.recvSeq = seq
+	a.conn.WriteMsg(frameHeader(frameAck, 0, seq))
+	return true
+}
+
+// the last DedupLen keys are remembered
+func (a *agent) duplicate(key string) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.keys == nil {
+		a.keys = make(map[string]struct{})
+	}
+	if _, ok := a.keys[key]; ok {
+		return true
+	}
+
+	if len(a.keyRing) == a.gate.DedupLen {
+		delete(a.keys, a.keyRing[0])
+		a.keyRing = append(a.keyRing[:0], a.keyRing[1:]...)
+	}
+	a.keys[key] = struct{}{}
+	a.keyRing = append(a.keyRing, key)
+	return false
+}
+
+func (a *agent) retransmit(from uint32) {
+	a.Lock()
+	defer a.Unlock()
+
+	if from != 0 {
+		a.resend(from)
+	}
+}
+
+// the messages before from are acknowledged
+// a NACK during a replay does not start another one, the gap it reports is
+// resent by the running replay
+// goroutine not safe, the caller holds the lock
+func (a *agent) resend(from uint32) {
+	a.trim(from - 1)
+	if a.replay != 0 {
+		return
+	}
+	if len(a.pending) == 0 {
+		return
+	}
+	a.replay = a.pending[0].seq
+	a.replayMore()
+}
+
+// goroutine not safe, the caller holds the lock
+func (a *agent) replayMore() {
+	a.replayTimer = nil
+	n := replayBatch
+	if q, ok := a.conn.(network.WriteQueue); ok {
+		if free := q.WriteQueueFree(); free >= 0 {
+			n = free / 2
+		}
+	}
+
+	for _, m := range a.pending {
+		if m.seq < a.replay {
+			continue
+		}
+		if n == 0 {
+			gen := a.replayGen
+			a.replayTimer = time.AfterFunc(replayDelay, func() {
+				a.replayLater(gen)
+			})
+			return
+		}
+		a.conn.WriteMsg(append([][]byte{frameHeader(frameData, 0, m.seq)}, m.data...)...)
+		a.replay = m.seq + 1
+		n--
+	}
+	a.replay = 0
+}
+
+func (a *agent) replayLater(gen uint32) {
+	a.Lock()
+	defer a.Unlock()
+
+	// not a stale timer of a stopped replay
+	if a.replay != 0 && a.replayGen == gen && a.attached && !a.closed {
+		a.replayMore()
+	}
+}
+
+// goroutine not safe, the caller holds the lock
+func (a *agent) stopReplay() {
+	if a.replayTimer != nil {
+		a.replayTimer.Stop()
+		a.replayTimer = nil
+	}
+	a.replay = 0
+	a.replayGen++
+}
+
+func (a *agent) ack(seq uint32) {
+	a.Lock()
+	a.trim(seq)
+	a.Unlock()
+}
+
+// goroutine not safe, the caller holds the lock
+func (a *agent) trim(ack uint32) {
+	i := 0
+	for i < len(a.pending) && a.pending[i].seq <= ack {
+		i++
+	}
+	if i > 0 {
+		a.pending = append(a.pending[:0], a.pending[i:]...)
+	}
+}
+
+// the message is dropped if the session is closed
+func (a *agent) writeData(data [][]byte) error {
+	a.Lock()
+	if a.closed {
+		a.Unlock()
+		return nil
+	}
+	if len(a.pending) >= a.gate.MaxPendingMsg {
+		a.Unlock()
+		a.close(true)
+		return fmt.Errorf("session %v closed: too many pending messages", a.id)
+	}
+
+	a.seq++
+	a.pending = append(a.pending, pendingMsg{a.seq, data})
+	var err error
+	// sent in order by the running replay
+	if a.attached && a.replay == 0 {
+		err = a.conn.WriteMsg(append([][]byte{frameHeader(frameData, 0, a.seq)}, data...)...)
+	}
+	a.Unlock()
+	return err
+}
@@ -0,0 +1,337 @@
+package gate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/chanrpc"
+	"github.com/hongjie104/leaf/log"
+	jsonproc "github.com/hongjie104/leaf/network/json"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Logger = zap.NewNop().Sugar()
+}
+
+type Text struct {
+	Text string `sproto:"string,0,name=text"`
+}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// the gate may not listen yet
+func dial(t *testing.T, addr string) *testClient {
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			return &testClient{t, conn}
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c *testClient) write(typ byte, flags byte, seq uint32, payload []byte) {
+	frame := append(frameHeader(typ, flags, seq), payload...)
+	b := make([]byte, 2+len(frame))
+	binary.BigEndian.PutUint16(b, uint16(len(frame)))
+	copy(b[2:], frame)
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) readMsg() []byte {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	var l [2]byte
+	if _, err := io.ReadFull(c.conn, l[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(c.conn, b); err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+// the json processor prefixes an id of 2 bytes
+func (c *testClient) parseText(data []byte) string {
+	var m map[string]Text
+	if err := json.Unmarshal(data[2:], &m); err != nil {
+		c.t.Fatal(err)
+	}
+	return m["Text"].Text
+}
+
+func (c *testClient) read() (byte, byte, uint32, []byte) {
+	typ, flags, seq, payload, err := parseFrame(c.readMsg())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return typ, flags, seq, payload
+}
+
+func (c *testClient) readText() (uint32, string) {
+	typ, _, seq, payload := c.read()
+	if typ != frameData {
+		c.t.Fatalf("frame %v, want DATA", typ)
+	}
+	return seq, c.parseText(payload)
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+type testGate struct {
+	*Gate
+	newAgent   chan UserAgent
+	closeAgent chan UserAgent
+	received   chan string
+	login      chan string
+	closeSig   chan bool
+	done       chan struct{}
+}
+
+func startGate(t *testing.T, gate *Gate) *testGate {
+	tg := &testGate{
+		Gate:       gate,
+		newAgent:   make(chan UserAgent, 10),
+		closeAgent: make(chan UserAgent, 10),
+		received:   make(chan string, 10),
+		login:      make(chan string, 10),
+		closeSig:   make(chan bool),
+		done:       make(chan struct{}),
+	}
+
+	processor := jsonproc.NewProcessor()
+	processor.Register(&Text{})
+	processor.Register(&Login{})
+	processor.SetHandler(&Login{}, func(args []interface{}) {
+		tg.login <- args[0].(*Login).Name
+	})
+	processor.SetHandler(&Text{}, func(args []interface{}) {
+		tg.received <- args[0].(*Text).Text
+	})
+
+	server := chanrpc.NewServer(10)
+	server.Register("NewAgent", func(args []interface{}) {
+		tg.newAgent <- args[0].(UserAgent)
+	})
+	server.Register("CloseAgent", func(args []interface{}) {
+		tg.closeAgent <- args[0].(UserAgent)
+	})
+	go func() {
+		for ci := range server.ChanCall {
+			server.Exec(ci)
+		}
+	}()
+
+	gate.TCPAddr = freeAddr(t)
+	gate.Processor = processor
+	gate.AgentChanRPC = server
+	go func() {
+		gate.Run(tg.closeSig)
+		close(tg.done)
+	}()
+
+	return tg
+}
+
+func (tg *testGate) stop() {
+	tg.closeSig <- true
+	<-tg.done
+}
+
+func (c *testClient) writeText(flags byte, seq uint32, key string, text string) {
+	var payload []byte
+	if flags&flagIdempotent != 0 {
+		payload = append([]byte{byte(len(key))}, key...)
+	}
+	data, _ := json.Marshal(map[string]Text{"Text": {text}})
+	c.write(frameData, flags, seq, append(payload, data...))
+}
+
+func TestSessionResume(t *testing.T) {
+	gate := startGate(t, &Gate{
+		Session:        true,
+		SessionTimeout: 200 * time.Millisecond,
+	})
+	defer gate.stop()
+	newAgent := gate.newAgent
+	closeAgent := gate.closeAgent
+
+	// new session
+	c := dial(t, gate.TCPAddr)
+	c.write(frameHello, 0, 0, nil)
+	typ, flags, _, welcome := c.read()
+	if typ != frameWelcome || flags&flagResumed != 0 || len(welcome) != 8+tokenLen {
+		t.Fatalf("welcome %v %v %v", typ, flags, welcome)
+	}
+	a := <-newAgent
+	if a.ID() != binary.BigEndian.Uint64(welcome) || gate.Agent(a.ID()) != a {
+		t.Fatalf("agent %v not registered", a.ID())
+	}
+
+	a.WriteMsg(&Text{"1"})
+	a.WriteMsg(&Text{"2"})
+	if seq, text := c.readText(); seq != 1 || text != "1" {
+		t.Fatalf("got %v %v, want 1 1", seq, text)
+	}
+	c.write(frameAck, 0, 1, nil)
+	c.readText()
+	c.conn.Close()
+
+	// sent while detached
+	time.Sleep(50 * time.Millisecond)
+	a.WriteMsg(&Text{"3"})
+
+	// a wrong token starts a new session
+	bad := dial(t, gate.TCPAddr)
+	wrong := append([]byte(nil), welcome...)
+	wrong[8] ^= 0xff
+	bad.write(frameHello, 0, 0, wrong)
+	if _, flags, _, _ := bad.read(); flags&flagResumed != 0 {
+		t.Fatal("resumed with a wrong token")
+	}
+	other := <-newAgent
+	bad.conn.Close()
+
+	// resume, 2 and 3 are replayed
+	c = dial(t, gate.TCPAddr)
+	c.write(frameHello, 0, 1, welcome)
+	if typ, flags, _, _ := c.read(); typ != frameWelcome || flags&flagResumed == 0 {
+		t.Fatalf("welcome %v %v, want resumed", typ, flags)
+	}
+	for _, want := range []string{"2", "3"} {
+		if _, text := c.readText(); text != want {
+			t.Fatalf("got %v, want %v", text, want)
+		}
+	}
+
+	// expired after SessionTimeout
+	if closed := <-closeAgent; closed != other {
+		t.Fatalf("agent %v closed, want %v", closed.ID(), other.ID())
+	}
+	c.conn.Close()
+	select {
+	case closed := <-closeAgent:
+		if closed != a {
+			t.Fatalf("agent %v closed, want %v", closed.ID(), a.ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session not expired")
+	}
+	if gate.Len() != 0 {
+		t.Fatalf("%v sessions left", gate.Len())
+	}
+	select {
+	case <-newAgent:
+		t.Fatal("NewAgent on resume")
+	default:
+	}
+}
+
+func TestSessionSequence(t *testing.T) {
+	gate := startGate(t, &Gate{Session: true})
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	c.write(frameHello, 0, 0, nil)
+	c.read()
+	a := <-gate.newAgent
+
+	expect := func(typ byte, seq uint32) {
+		t.Helper()
+		if got, _, s, _ := c.read(); got != typ || s != seq {
+			t.Fatalf("frame %v %v, want %v %v", got, s, typ, seq)
+		}
+	}
+
+	c.writeText(0, 1, "", "a")
+	expect(frameAck, 1)
+	// duplicate
+	c.writeText(0, 1, "", "a")
+	expect(frameAck, 1)
+	// gap
+	c.writeText(0, 3, "", "c")
+	expect(frameNack, 2)
+	c.writeText(flagIdempotent, 2, "buy-1", "b")
+	expect(frameAck, 2)
+	// retried with a new seq
+	c.writeText(flagIdempotent, 3, "buy-1", "b")
+	expect(frameAck, 3)
+	c.writeText(0, 4, "", "d")
+	expect(frameAck, 4)
+	for _, want := range []string{"a", "b", "d"} {
+		if got := <-gate.received; got != want {
+			t.Fatalf("received %v, want %v", got, want)
+		}
+	}
+
+	// lost by the client
+	a.WriteMsg(&Text{"1"})
+	a.WriteMsg(&Text{"2"})
+	c.readText()
+	c.readText()
+	c.write(frameNack, 0, 2, nil)
+	if seq, text := c.readText(); seq != 2 || text != "2" {
+		t.Fatalf("got %v %v, want 2 2", seq, text)
+	}
+}
+
+func TestSessionReplay(t *testing.T) {
+	gate := startGate(t, &Gate{Session: true, PendingWriteNum: 8, MaxPendingMsg: 50})
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	c.write(frameHello, 0, 0, nil)
+	c.read()
+	a := <-gate.newAgent
+
+	for i := 1; i <= 40; i++ {
+		a.WriteMsg(&Text{fmt.Sprint(i)})
+		c.readText()
+	}
+
+	// the replay is larger than the write queue, the second NACK does not
+	// start another one
+	c.write(frameNack, 0, 1, nil)
+	c.write(frameNack, 0, 1, nil)
+	// acknowledged after the NACKs are handled
+	c.writeText(0, 1, "", "a")
+	for i := 1; i <= 41; {
+		typ, _, seq, payload := c.read()
+		if typ == frameAck {
+			a.WriteMsg(&Text{"41"})
+			continue
+		}
+		if text := c.parseText(payload); typ != frameData || seq != uint32(i) || text != fmt.Sprint(i) {
+			t.Fatalf("got %v %v %v, want %v", typ, seq, text, i)
+		}
+		i++
+	}
+
+	a.WriteMsg(&Text{"42"})
+	if seq, _ := c.readText(); seq != 42 {
+		t.Fatalf("got %v, want 42", seq)
+	}
+}
@@ -0,0 +1,111 @@
+package gate
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/network"
+)
+
+// the first read waits HandshakeTimeout, the others ReadIdleTimeout
+// the timeouts are ignored if the connection is not a network.ReadDeadliner
+func (l *link) read() ([]byte, error) {
+	timeout := l.gate.ReadIdleTimeout
+	if !l.started && l.gate.HandshakeTimeout > 0 {
+		timeout = l.gate.HandshakeTimeout
+	}
+	l.started = true
+
+	d, ok := l.conn.(network.ReadDeadliner)
+	if ok && timeout > 0 {
+		d.SetReadDeadline(time.Now().Add(timeout))
+		l.deadline = true
+	} else if ok && l.deadline {
+		d.SetReadDeadline(time.Time{})
+		l.deadline = false
+	}
+	return l.conn.ReadMsg()
+}
+
+// a pong read by the websocket connection delays the read deadline
+func (l *link) watchPong() {
+	wsConn, ok := l.conn.(*network.WSConn)
+	if !ok || l.gate.ReadIdleTimeout <= 0 {
+		return
+	}
+	wsConn.SetPongHandler(func() {
+		wsConn.SetReadDeadline(time.Now().Add(l.gate.ReadIdleTimeout))
+	})
+}
+
+func (l *link) isHeartbeat(msg interface{}) bool {
+	return l.gate.HeartbeatMsg != nil && reflect.TypeOf(msg) == reflect.TypeOf(l.gate.HeartbeatMsg)
+}
+
+func (l *link) startHeartbeat() {
+	if l.gate.WriteIdleTimeout <= 0 {
+		return
+	}
+
+	l.mutexHeartbeat.Lock()
+	l.heartbeat = time.AfterFunc(l.gate.WriteIdleTimeout, l.beat)
+	l.mutexHeartbeat.Unlock()
+}
+
+func (l *link) stopHeartbeat() {
+	l.mutexHeartbeat.Lock()
+	if l.heartbeat != nil {
+		l.heartbeat.Stop()
+		l.heartbeat = nil
+	}
+	l.mutexHeartbeat.Unlock()
+}
+
+// a heartbeat is sent if nothing has been written for WriteIdleTimeout
+func (l *link) beat() {
+	idle := l.gate.WriteIdleTimeout
+	if d := time.Since(time.Unix(0, atomic.LoadInt64(&l.a.lastWrite))); d < idle {
+		l.resetHeartbeat(idle - d)
+		return
+	}
+
+	var err error
+	if wsConn, ok := l.conn.(*network.WSConn); ok {
+		err = wsConn.Ping()
+	} else if l.gate.Session {
+		l.a.Lock()
+		err = l.conn.WriteMsg(frameHeader(frameAck, 0, l.a.recvSeq))
+		l.a.Unlock()
+	} else if l.gate.HeartbeatMsg != nil {
+		l.a.WriteMsg(l.gate.HeartbeatMsg)
+	}
+	if err != nil {
+		log.Debugf("write heartbeat error: %v", err)
+	}
+
+	atomic.StoreInt64(&l.a.lastWrite, time.Now().UnixNano())
+	l.resetHeartbeat(idle)
+}
+
+func (l *link) resetHeartbeat(d time.Duration) {
+	l.mutexHeartbeat.Lock()
+	if l.heartbeat != nil {
+		l.heartbeat.Reset(d)
+	}
+	l.mutexHeartbeat.Unlock()
+}
+
+// the agent is closed if no user is bound within LoginTimeout
+func (a *agent) startLogin() {
+	if a.gate.LoginTimeout <= 0 {
+		return
+	}
+	a.login = time.AfterFunc(a.gate.LoginTimeout, func() {
+		if a.UserID() == nil {
+			log.Debugf("close agent %v: login timeout", a.id)
+			a.Close()
+		}
+	})
+}
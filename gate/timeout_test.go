@@ -0,0 +1,85 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	gate := startGate(t, &Gate{
+		ReadIdleTimeout:  200 * time.Millisecond,
+		WriteIdleTimeout: 50 * time.Millisecond,
+		HeartbeatMsg:     &Text{"heartbeat"},
+	})
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	a := <-gate.newAgent
+
+	// the heartbeats of the client are not routed
+	start := time.Now()
+	for time.Since(start) < 400*time.Millisecond {
+		if got := c.parseText(c.readMsg()); got != "heartbeat" {
+			t.Fatalf("got %v, want heartbeat", got)
+		}
+		c.writeMsg("heartbeat")
+	}
+	select {
+	case got := <-gate.received:
+		t.Fatalf("heartbeat routed: %v", got)
+	case closed := <-gate.closeAgent:
+		t.Fatalf("agent %v closed while alive", closed.ID())
+	default:
+	}
+
+	// silent
+	select {
+	case closed := <-gate.closeAgent:
+		if closed != a {
+			t.Fatalf("agent %v closed, want %v", closed.ID(), a.ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle agent not closed")
+	}
+}
+
+func TestLoginTimeout(t *testing.T) {
+	gate := startGate(t, &Gate{
+		HandshakeTimeout: 100 * time.Millisecond,
+		LoginTimeout:     200 * time.Millisecond,
+	})
+	defer gate.stop()
+
+	// no first message
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	<-gate.newAgent
+	select {
+	case <-gate.closeAgent:
+	case <-time.After(time.Second):
+		t.Fatal("handshake timeout not reached")
+	}
+
+	// logged in
+	c = dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	a := <-gate.newAgent
+	c.writeMsg("login")
+	<-gate.received
+	a.BindUser("alice")
+
+	// not logged in
+	c = dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	b := <-gate.newAgent
+	c.writeMsg("hello")
+	select {
+	case closed := <-gate.closeAgent:
+		if closed != b {
+			t.Fatalf("agent %v closed, want %v", closed.ID(), b.ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("login timeout not reached")
+	}
+}
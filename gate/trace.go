@@ -0,0 +1,166 @@
+package gate
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hongjie104/leaf/log"
+)
+
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (dir Direction) String() string {
+	if dir == Inbound {
+		return "in"
+	}
+	return "out"
+}
+
+type Tracer interface {
+	// data is the output of Processor.Marshal for an outbound message, it
+	// must not be modified, nor kept after the call for an inbound one
+	// must goroutine safe
+	Trace(a Agent, dir Direction, msg interface{}, data [][]byte)
+}
+
+func (gate *Gate) trace(a *agent, dir Direction, msg interface{}, data [][]byte) {
+	if gate.Tracer != nil {
+		gate.Tracer.Trace(a, dir, msg, data)
+	}
+}
+
+// the name of the type of the message, the pointer is ignored
+func MsgName(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		return "nil"
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// FilterTracer passes the selected messages to Tracer
+type FilterTracer struct {
+	Tracer Tracer
+	// names of types, all the types if empty
+	Include []string
+	Exclude []string
+	// the ratio of the messages passed, 0 to pass them all
+	Sample  float64
+	once    sync.Once
+	include map[string]struct{}
+	exclude map[string]struct{}
+}
+
+func (ft *FilterTracer) init() {
+	ft.include = make(map[string]struct{})
+	for _, name := range ft.Include {
+		ft.include[name] = struct{}{}
+	}
+	ft.exclude = make(map[string]struct{})
+	for _, name := range ft.Exclude {
+		ft.exclude[name] = struct{}{}
+	}
+}
+
+func (ft *FilterTracer) Trace(a Agent, dir Direction, msg interface{}, data [][]byte) {
+	ft.once.Do(ft.init)
+
+	name := MsgName(msg)
+	if _, ok := ft.include[name]; !ok && len(ft.include) > 0 {
+		return
+	}
+	if _, ok := ft.exclude[name]; ok {
+		return
+	}
+	if ft.Sample > 0 && ft.Sample < 1 && rand.Float64() >= ft.Sample {
+		return
+	}
+	ft.Tracer.Trace(a, dir, msg, data)
+}
+
+// zero if a is not a UserAgent
+func agentUser(a Agent) (uint64, interface{}) {
+	if ua, ok := a.(UserAgent); ok {
+		return ua.ID(), ua.UserID()
+	}
+	return 0, nil
+}
+
+// LogTracer logs the messages at the debug level with structured fields
+type LogTracer struct{}
+
+func (LogTracer) Trace(a Agent, dir Direction, msg interface{}, data [][]byte) {
+	id, user := agentUser(a)
+	log.Logger.Debugw("message",
+		"agent", id,
+		"user", user,
+		"dir", dir.String(),
+		"type", MsgName(msg),
+		"msg", msg,
+	)
+}
+
+// FileTracer writes the messages to a file, one json object per line
+// goroutine safe
+type FileTracer struct {
+	mutex sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+}
+
+type traceRecord struct {
+	Time  time.Time   `json:"time"`
+	Agent uint64      `json:"agent"`
+	User  interface{} `json:"user,omitempty"`
+	Dir   string      `json:"dir"`
+	Type  string      `json:"type"`
+	Msg   interface{} `json:"msg"`
+}
+
+func NewFileTracer(name string) (*FileTracer, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ft := new(FileTracer)
+	ft.file = file
+	ft.enc = json.NewEncoder(file)
+	return ft, nil
+}
+
+func (ft *FileTracer) Trace(a Agent, dir Direction, msg interface{}, data [][]byte) {
+	id, user := agentUser(a)
+	r := traceRecord{
+		Time:  time.Now(),
+		Agent: id,
+		User:  user,
+		Dir:   dir.String(),
+		Type:  MsgName(msg),
+		Msg:   msg,
+	}
+
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	if err := ft.enc.Encode(r); err != nil {
+		log.Debugf("trace message %v error: %v", r.Type, err)
+	}
+}
+
+func (ft *FileTracer) Close() error {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return ft.file.Close()
+}
@@ -0,0 +1,79 @@
+package gate
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type testTracer struct {
+	mutex sync.Mutex
+	trace []string
+}
+
+func (tt *testTracer) Trace(a Agent, dir Direction, msg interface{}, data [][]byte) {
+	tt.mutex.Lock()
+	defer tt.mutex.Unlock()
+	tt.trace = append(tt.trace, dir.String()+" "+MsgName(msg)+" "+msg.(*Text).Text)
+}
+
+func TestTracer(t *testing.T) {
+	tt := new(testTracer)
+	name := filepath.Join(t.TempDir(), "trace.log")
+	ft, err := NewFileTracer(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gate := startGate(t, &Gate{
+		Tracer: &FilterTracer{
+			Tracer:  tracers{tt, ft},
+			Exclude: []string{"Login"},
+		},
+	})
+	defer gate.stop()
+
+	c := dial(t, gate.TCPAddr)
+	defer c.conn.Close()
+	a := <-gate.newAgent
+	c.writeJSON(map[string]Login{"Login": {"alice"}})
+	c.writeMsg("ping")
+	<-gate.received
+	a.WriteMsg(&Text{"pong"})
+	c.readMsg()
+	ft.Close()
+
+	want := []string{"in Text ping", "out Text pong"}
+	tt.mutex.Lock()
+	defer tt.mutex.Unlock()
+	if len(tt.trace) != len(want) || tt.trace[0] != want[0] || tt.trace[1] != want[1] {
+		t.Fatalf("trace %q, want %q", tt.trace, want)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines int
+	for s := bufio.NewScanner(f); s.Scan(); lines++ {
+		var r traceRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil || r.Agent != a.ID() {
+			t.Fatalf("record %s: %v", s.Bytes(), err)
+		}
+	}
+	if lines != 2 {
+		t.Fatalf("%v records, want 2", lines)
+	}
+}
+
+type tracers []Tracer
+
+func (ts tracers) Trace(a Agent, dir Direction, msg interface{}, data [][]byte) {
+	for _, t := range ts {
+		t.Trace(a, dir, msg, data)
+	}
+}
@@ -0,0 +1,122 @@
+package g
+
+import (
+	"context"
+)
+
+// returned by the Go variants taking a context
+type Handle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newHandle(ctx context.Context) *Handle {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	h := new(Handle)
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	return h
+}
+
+func (h *Handle) Context() context.Context {
+	return h.ctx
+}
+
+// f is skipped if not started yet, cb gets context.Canceled unless it has run
+// goroutine safe
+func (h *Handle) Cancel() {
+	h.cancel()
+}
+
+// goroutine safe
+func (h *Handle) Err() error {
+	return h.ctx.Err()
+}
+
+func (h *Handle) f(f func(ctx context.Context)) func() {
+	return func() {
+		if h.ctx.Err() == nil {
+			f(h.ctx)
+		}
+	}
+}
+
+// the error is checked when cb is called, so a cancel issued after f
+// returned is still reported
+func (h *Handle) cb(cb func(err error)) func() {
+	return func() {
+		err := h.ctx.Err()
+		h.cancel()
+		if cb != nil {
+			cb(err)
+		}
+	}
+}
+
+func (g *Go) GoCtx(ctx context.Context, f func(ctx context.Context), cb func(err error)) *Handle {
+	h := newHandle(ctx)
+	g.Go(h.f(f), h.cb(cb))
+	return h
+}
+
+func (c *LinearContext) GoCtx(ctx context.Context, f func(ctx context.Context), cb func(err error)) *Handle {
+	h := newHandle(ctx)
+	c.push(&LinearGo{f: h.f(f), cb: h.cb(cb), cancel: h.cancel})
+	return h
+}
+
+func (c *KeyedContext) GoCtx(ctx context.Context, key interface{}, f func(ctx context.Context), cb func(err error)) *Handle {
+	h := newHandle(ctx)
+	c.push(key, &LinearGo{f: h.f(f), cb: h.cb(cb), cancel: h.cancel})
+	return h
+}
+
+// drop the jobs which have not started yet
+// the callbacks of GoCtx get context.Canceled, the callbacks of Go are not called
+// goroutine safe
+func (c *LinearContext) Cancel() {
+	c.mutexLinearGo.Lock()
+	defer c.mutexLinearGo.Unlock()
+
+	for e := c.linearGo.Front(); e != nil; e = e.Next() {
+		e.Value.(*LinearGo).drop()
+	}
+}
+
+// drop the jobs of key which have not started yet, see LinearContext.Cancel
+// goroutine safe
+func (c *KeyedContext) Cancel(key interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	q, ok := c.queues[key]
+	if !ok {
+		return
+	}
+	for e := q.Front(); e != nil; e = e.Next() {
+		e.Value.(*LinearGo).drop()
+	}
+}
+
+// drop all the jobs which have not started yet
+// goroutine safe
+func (c *KeyedContext) CancelAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, q := range c.queues {
+		for e := q.Front(); e != nil; e = e.Next() {
+			e.Value.(*LinearGo).drop()
+		}
+	}
+}
+
+func (e *LinearGo) drop() {
+	if e.cancel != nil {
+		e.cancel()
+	} else {
+		e.dropped = true
+	}
+}
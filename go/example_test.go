@@ -1,6 +1,7 @@
 package g_test
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -69,3 +70,110 @@ func ExampleLinearContext() {
 	// 1
 	// 2
 }
+
+func ExamplePool() {
+	d := g.New(10)
+	p := g.NewPool("example", 1, 1, g.Abort)
+	defer p.Close()
+
+	started := make(chan struct{})
+	block := make(chan struct{})
+	d.GoPool(p, func() {
+		close(started)
+		<-block
+	}, func() {
+		fmt.Println("1")
+	})
+	<-started
+	d.GoPool(p, func() {}, func() {
+		fmt.Println("2")
+	})
+
+	// the worker is busy and the queue is full
+	err := d.GoPool(p, func() {}, nil)
+	fmt.Println(err)
+
+	close(block)
+	d.Close()
+
+	st := p.Stats()
+	fmt.Println(st.Submitted, st.Completed, st.Rejected)
+
+	// Output:
+	// pool full
+	// 1
+	// 2
+	// 2 2 1
+}
+
+func ExampleKeyedContext() {
+	d := g.New(10)
+
+	// one key per player, two goroutines at most
+	c := d.NewKeyedContext(2)
+	saved := make(map[string]*[]int)
+	for _, player := range []string{"p1", "p2", "p3"} {
+		saved[player] = new([]int)
+	}
+	for i := 1; i <= 3; i++ {
+		for player, s := range saved {
+			s, i := s, i
+			c.Go(player, func() {
+				time.Sleep(time.Millisecond)
+				*s = append(*s, i)
+			}, nil)
+		}
+	}
+
+	d.Close()
+
+	fmt.Println(*saved["p1"], *saved["p2"], *saved["p3"])
+
+	// Output:
+	// [1 2 3] [1 2 3] [1 2 3]
+}
+
+func ExampleHandle() {
+	d := g.New(10)
+
+	// cancelled while loading
+	loaded := make(chan struct{})
+	h := d.GoCtx(context.Background(), func(ctx context.Context) {
+		fmt.Println("loading")
+		close(loaded)
+	}, func(err error) {
+		fmt.Println(err)
+	})
+	<-loaded
+	h.Cancel()
+	d.Cb(<-d.ChanCb)
+
+	// queued jobs are dropped
+	c := d.NewLinearContext()
+	started := make(chan struct{})
+	block := make(chan struct{})
+	c.Go(func() {
+		close(started)
+		<-block
+	}, nil)
+	c.GoCtx(context.Background(), func(ctx context.Context) {
+		fmt.Println("will not print")
+	}, func(err error) {
+		fmt.Println(err)
+	})
+	c.Go(func() {
+		fmt.Println("will not print")
+	}, func() {
+		fmt.Println("will not print")
+	})
+	<-started
+	c.Cancel()
+	close(block)
+
+	d.Close()
+
+	// Output:
+	// loading
+	// context canceled
+	// context canceled
+}
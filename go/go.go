@@ -16,8 +16,10 @@ type Go struct {
 }
 
 type LinearGo struct {
-	f  func()
-	cb func()
+	f       func()
+	cb      func()
+	cancel  func()
+	dropped bool
 }
 
 type LinearContext struct {
@@ -91,10 +93,14 @@ func (g *Go) NewLinearContext() *LinearContext {
 }
 
 func (c *LinearContext) Go(f func(), cb func()) {
+	c.push(&LinearGo{f: f, cb: cb})
+}
+
+func (c *LinearContext) push(e *LinearGo) {
 	c.g.pendingGo++
 
 	c.mutexLinearGo.Lock()
-	c.linearGo.PushBack(&LinearGo{f: f, cb: cb})
+	c.linearGo.PushBack(e)
 	c.mutexLinearGo.Unlock()
 
 	go func() {
@@ -105,6 +111,11 @@ func (c *LinearContext) Go(f func(), cb func()) {
 		e := c.linearGo.Remove(c.linearGo.Front()).(*LinearGo)
 		c.mutexLinearGo.Unlock()
 
+		if e.dropped {
+			c.g.ChanCb <- nil
+			return
+		}
+
 		defer func() {
 			c.g.ChanCb <- e.cb
 			if r := recover(); r != nil {
@@ -0,0 +1,102 @@
+package g
+
+import (
+	"container/list"
+	"sync"
+)
+
+// jobs sharing a key run one by one in order, different keys run in parallel
+// at most limit goroutines are used whatever the number of queued jobs
+type KeyedContext struct {
+	g       *Go
+	limit   int
+	mutex   sync.Mutex
+	queues  map[interface{}]*list.List
+	ready   *list.List
+	running int
+}
+
+func (g *Go) NewKeyedContext(limit int) *KeyedContext {
+	if limit <= 0 {
+		limit = 1
+	}
+
+	c := new(KeyedContext)
+	c.g = g
+	c.limit = limit
+	c.queues = make(map[interface{}]*list.List)
+	c.ready = list.New()
+	return c
+}
+
+func (c *KeyedContext) Go(key interface{}, f func(), cb func()) {
+	c.push(key, &LinearGo{f: f, cb: cb})
+}
+
+func (c *KeyedContext) push(key interface{}, e *LinearGo) {
+	c.g.pendingGo++
+
+	c.mutex.Lock()
+	q, ok := c.queues[key]
+	if !ok {
+		q = list.New()
+		c.queues[key] = q
+	}
+	q.PushBack(e)
+
+	// the key is already running or waiting for a goroutine
+	if ok {
+		c.mutex.Unlock()
+		return
+	}
+
+	if c.running < c.limit {
+		c.running++
+		c.mutex.Unlock()
+		go c.run(key)
+		return
+	}
+
+	c.ready.PushBack(key)
+	c.mutex.Unlock()
+}
+
+func (c *KeyedContext) run(key interface{}) {
+	for {
+		c.mutex.Lock()
+		q := c.queues[key]
+		e := q.Remove(q.Front()).(*LinearGo)
+		c.mutex.Unlock()
+
+		if e.dropped {
+			c.g.ChanCb <- nil
+		} else {
+			execF(e.f)
+			c.g.ChanCb <- e.cb
+		}
+
+		c.mutex.Lock()
+		if q.Len() == 0 {
+			delete(c.queues, key)
+			if c.ready.Len() == 0 {
+				c.running--
+				c.mutex.Unlock()
+				return
+			}
+			key = c.ready.Remove(c.ready.Front())
+		} else if c.ready.Len() > 0 {
+			// take turns with the waiting keys
+			c.ready.PushBack(key)
+			key = c.ready.Remove(c.ready.Front())
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// number of keys having queued or running jobs
+// goroutine safe
+func (c *KeyedContext) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.queues)
+}
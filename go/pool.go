@@ -0,0 +1,277 @@
+package g
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hongjie104/leaf/conf"
+	"github.com/hongjie104/leaf/log"
+)
+
+// what to do when the queue of a pool is full
+type RejectPolicy int
+
+const (
+	// GoPool returns ErrPoolFull
+	Abort RejectPolicy = iota
+	// GoPool waits for room in the queue, the workers never wait for the
+	// owner, so it may block on the goroutine serving ChanCb
+	Block
+	// f and cb run on the calling goroutine
+	CallerRuns
+	// the oldest queued job is dropped, its cb is never called
+	// without a queue, GoPool returns ErrPoolFull like Abort
+	DiscardOldest
+)
+
+var (
+	ErrPoolFull   = errors.New("pool full")
+	ErrPoolClosed = errors.New("pool closed")
+)
+
+// bounded goroutines shared by any number of Go
+// goroutine safe
+type Pool struct {
+	name      string
+	workers   int
+	policy    RejectPolicy
+	jobs      chan *poolJob
+	mutex     sync.RWMutex
+	closeFlag bool
+	wg        sync.WaitGroup
+	running   int64
+	submitted uint64
+	completed uint64
+	rejected  uint64
+	discarded uint64
+	callerRun uint64
+}
+
+type poolJob struct {
+	g  *Go
+	f  func()
+	cb func()
+}
+
+type PoolStats struct {
+	Name       string
+	Workers    int
+	Running    int
+	Queued     int
+	QueueLen   int
+	Submitted  uint64
+	Completed  uint64
+	Rejected   uint64
+	Discarded  uint64
+	CallerRuns uint64
+}
+
+var (
+	pools      = make(map[string]*Pool)
+	mutexPools sync.Mutex
+)
+
+// the pool is registered under name
+func NewPool(name string, maxWorkers int, queueLen int, policy RejectPolicy) *Pool {
+	if maxWorkers <= 0 {
+		maxWorkers = runtime.NumCPU()
+		log.Infof("invalid maxWorkers, reset to %v", maxWorkers)
+	}
+	if queueLen < 0 {
+		queueLen = 0
+		log.Infof("invalid queueLen, reset to %v", queueLen)
+	}
+
+	p := new(Pool)
+	p.name = name
+	p.workers = maxWorkers
+	p.policy = policy
+	p.jobs = make(chan *poolJob, queueLen)
+
+	mutexPools.Lock()
+	if _, ok := pools[name]; ok {
+		mutexPools.Unlock()
+		panic(fmt.Sprintf("pool %v: already registered", name))
+	}
+	pools[name] = p
+	mutexPools.Unlock()
+
+	p.wg.Add(maxWorkers)
+	for i := 0; i < maxWorkers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+func GetPool(name string) *Pool {
+	mutexPools.Lock()
+	defer mutexPools.Unlock()
+	return pools[name]
+}
+
+// sorted by name
+func RangePools(f func(p *Pool)) {
+	mutexPools.Lock()
+	var ps []*Pool
+	for _, p := range pools {
+		ps = append(ps, p)
+	}
+	mutexPools.Unlock()
+
+	sort.Slice(ps, func(i, j int) bool { return ps[i].name < ps[j].name })
+	for _, p := range ps {
+		f(p)
+	}
+}
+
+func (p *Pool) run() {
+	defer p.wg.Done()
+
+	for j := range p.jobs {
+		atomic.AddInt64(&p.running, 1)
+		execF(j.f)
+		atomic.AddInt64(&p.running, -1)
+		atomic.AddUint64(&p.completed, 1)
+
+		// the owner of the job might be blocked submitting to the pool, a
+		// full ChanCb must not stop the worker
+		select {
+		case j.g.ChanCb <- j.cb:
+		default:
+			go func(j *poolJob) {
+				j.g.ChanCb <- j.cb
+			}(j)
+		}
+	}
+}
+
+func execF(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("%v: %s", r, buf[:l])
+			} else {
+				log.Errorf("%v", r)
+			}
+		}
+	}()
+
+	f()
+}
+
+func (p *Pool) submit(j *poolJob) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closeFlag {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.jobs <- j:
+		atomic.AddUint64(&p.submitted, 1)
+		return nil
+	default:
+	}
+
+	switch p.policy {
+	case Block:
+		p.jobs <- j
+	case CallerRuns:
+		atomic.AddUint64(&p.callerRun, 1)
+		return errCallerRuns
+	case DiscardOldest:
+		// nothing to discard
+		if cap(p.jobs) == 0 {
+			atomic.AddUint64(&p.rejected, 1)
+			return ErrPoolFull
+		}
+		for {
+			select {
+			case p.jobs <- j:
+				atomic.AddUint64(&p.submitted, 1)
+				return nil
+			default:
+			}
+
+			select {
+			case old := <-p.jobs:
+				atomic.AddUint64(&p.discarded, 1)
+				// the owner of the job might be the calling goroutine
+				go func() {
+					old.g.ChanCb <- nil
+				}()
+			default:
+			}
+		}
+	default:
+		atomic.AddUint64(&p.rejected, 1)
+		return ErrPoolFull
+	}
+
+	atomic.AddUint64(&p.submitted, 1)
+	return nil
+}
+
+var errCallerRuns = errors.New("caller runs")
+
+func (p *Pool) Name() string {
+	return p.name
+}
+
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Name:       p.name,
+		Workers:    p.workers,
+		Running:    int(atomic.LoadInt64(&p.running)),
+		Queued:     len(p.jobs),
+		QueueLen:   cap(p.jobs),
+		Submitted:  atomic.LoadUint64(&p.submitted),
+		Completed:  atomic.LoadUint64(&p.completed),
+		Rejected:   atomic.LoadUint64(&p.rejected),
+		Discarded:  atomic.LoadUint64(&p.discarded),
+		CallerRuns: atomic.LoadUint64(&p.callerRun),
+	}
+}
+
+// the queued jobs are run before Close returns, the pool is unregistered
+func (p *Pool) Close() {
+	p.mutex.Lock()
+	if p.closeFlag {
+		p.mutex.Unlock()
+		return
+	}
+	p.closeFlag = true
+	close(p.jobs)
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+
+	mutexPools.Lock()
+	if pools[p.name] == p {
+		delete(pools, p.name)
+	}
+	mutexPools.Unlock()
+}
+
+// cb is delivered through ChanCb like Go
+func (g *Go) GoPool(p *Pool, f func(), cb func()) error {
+	err := p.submit(&poolJob{g: g, f: f, cb: cb})
+	if err == errCallerRuns {
+		g.pendingGo++
+		execF(f)
+		g.Cb(cb)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	g.pendingGo++
+	return nil
+}
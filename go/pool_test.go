@@ -0,0 +1,78 @@
+package g_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	g "github.com/hongjie104/leaf/go"
+)
+
+func TestPoolDiscardOldestNoQueue(t *testing.T) {
+	d := g.New(10)
+	p := g.NewPool("discard-no-queue", 1, 0, g.DiscardOldest)
+	defer p.Close()
+
+	started := make(chan struct{})
+	block := make(chan struct{})
+	// the worker may not wait for a job yet
+	for d.GoPool(p, func() {
+		close(started)
+		<-block
+	}, nil) != nil {
+		runtime.Gosched()
+	}
+	<-started
+
+	// the worker is busy and there is no queue
+	done := make(chan error, 1)
+	go func() {
+		done <- d.GoPool(p, func() {}, nil)
+	}()
+	select {
+	case err := <-done:
+		if err != g.ErrPoolFull {
+			t.Fatalf("GoPool: %v, want %v", err, g.ErrPoolFull)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GoPool never returned")
+	}
+
+	close(block)
+	d.Close()
+
+	st := p.Stats()
+	if st.Submitted != 1 || st.Rejected == 0 || st.Discarded != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestPoolBlockBurst(t *testing.T) {
+	d := g.New(4)
+	p := g.NewPool("block-burst", 2, 2, g.Block)
+	defer p.Close()
+
+	// more than ChanCb, the workers and the queue together, submitted by
+	// the goroutine serving ChanCb
+	const n = 32
+	done := make(chan struct{})
+	calls := 0
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := d.GoPool(p, func() {}, func() { calls++ }); err != nil {
+				t.Error(err)
+			}
+		}
+		d.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GoPool deadlocked")
+	}
+
+	if st := p.Stats(); calls != n || st.Submitted != n || st.Completed != n {
+		t.Fatalf("%v calls, stats %+v", calls, st)
+	}
+}
@@ -1,11 +1,18 @@
 package module
 
 import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hongjie104/leaf/chanrpc"
+	"github.com/hongjie104/leaf/conf"
 	"github.com/hongjie104/leaf/console"
 	g "github.com/hongjie104/leaf/go"
+	"github.com/hongjie104/leaf/log"
 	"github.com/hongjie104/leaf/timer"
 )
 
@@ -14,11 +21,29 @@ type Skeleton struct {
 	TimerDispatcherLen int
 	AsynCallLen        int
 	ChanRPCServer      *chanrpc.Server
-	g                  *g.Go
-	dispatcher         *timer.Dispatcher
-	client             *chanrpc.Client
-	server             *chanrpc.Server
-	commandServer      *chanrpc.Server
+	// optional, use a timing wheel with this precision for the timers
+	TimerTick time.Duration
+	// optional, timer.RealClock by default
+	Clock timer.Clock
+	// optional, a named skeleton shows up in the console command "skeleton"
+	Name string
+	// serve the sources in weighted round-robin order instead of a random select
+	Fair bool
+	// weight of each source in fair mode, 1 by default
+	Weights       map[Source]int
+	g             *g.Go
+	dispatcher    *timer.Dispatcher
+	client        *chanrpc.Client
+	server        *chanrpc.Server
+	commandServer *chanrpc.Server
+	chanTick      chan struct{}
+	ticks         []*TickLoop
+	clock         timer.Clock
+	chanPost      chan struct{}
+	posts         []func()
+	mutexPost     sync.Mutex
+	weights       [numSource]int
+	stats         stats
 }
 
 func (s *Skeleton) Init() {
@@ -33,7 +58,16 @@ func (s *Skeleton) Init() {
 	}
 
 	s.g = g.New(s.GoLen)
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	s.clock = s.Clock
+	if s.clock == nil {
+		s.clock = timer.RealClock
+	}
+	if s.TimerTick > 0 {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.TimerTick)
+	} else {
+		s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	}
+	s.dispatcher.SetClock(s.clock)
 	s.client = chanrpc.NewClient(s.AsynCallLen)
 	s.server = s.ChanRPCServer
 
@@ -41,31 +75,241 @@ func (s *Skeleton) Init() {
 		s.server = chanrpc.NewServer(0)
 	}
 	s.commandServer = chanrpc.NewServer(0)
+	s.chanTick = make(chan struct{}, 1)
+	s.chanPost = make(chan struct{}, 1)
+
+	for src := Source(0); src < numSource; src++ {
+		s.weights[src] = 1
+		if w, ok := s.Weights[src]; ok && w > 0 {
+			s.weights[src] = w
+		}
+	}
+
+	if s.Name != "" {
+		registerSkeleton(s)
+	}
 }
 
 func (s *Skeleton) Run(closeSig chan bool) {
+	s.stats.reset(time.Now())
+
+	if s.Fair {
+		s.runFair(closeSig)
+		return
+	}
+
+	for !s.wait(closeSig) {
+	}
+}
+
+func (s *Skeleton) runFair(closeSig chan bool) {
 	for {
 		select {
 		case <-closeSig:
-			s.commandServer.Close()
-			s.server.Close()
-			for !s.g.Idle() || !s.client.Idle() {
-				s.g.Close()
-				s.client.Close()
+			s.close()
+			return
+		default:
+		}
+
+		n := 0
+		for src := Source(0); src < numSource; src++ {
+			for i := 0; i < s.weights[src] && s.poll(src); i++ {
+				n++
 			}
+		}
+
+		// nothing pending, block on all the sources
+		if n == 0 && s.wait(closeSig) {
 			return
-		case ri := <-s.client.ChanAsynRet:
-			s.client.Cb(ri)
+		}
+	}
+}
+
+// handle one event, return true when closed
+func (s *Skeleton) wait(closeSig chan bool) bool {
+	select {
+	case <-closeSig:
+		s.close()
+		return true
+	case ri := <-s.client.ChanAsynRet:
+		s.execAsynRet(ri)
+	case ci := <-s.server.ChanCall:
+		s.execCall(ci)
+	case ci := <-s.commandServer.ChanCall:
+		s.execCommand(ci)
+	case cb := <-s.g.ChanCb:
+		s.execGoCb(cb)
+	case t := <-s.dispatcher.ChanTimer:
+		s.execTimer(t)
+	case <-s.chanTick:
+		s.execTick()
+	case <-s.chanPost:
+		s.execPost()
+	}
+	return false
+}
+
+// handle one event of src without blocking, return false when there is none
+func (s *Skeleton) poll(src Source) bool {
+	switch src {
+	case SourceCall:
+		select {
 		case ci := <-s.server.ChanCall:
-			s.server.Exec(ci)
-		case ci := <-s.commandServer.ChanCall:
-			s.commandServer.Exec(ci)
+			s.execCall(ci)
+			return true
+		default:
+		}
+	case SourceAsynRet:
+		select {
+		case ri := <-s.client.ChanAsynRet:
+			s.execAsynRet(ri)
+			return true
+		default:
+		}
+	case SourceGoCb:
+		select {
 		case cb := <-s.g.ChanCb:
-			s.g.Cb(cb)
+			s.execGoCb(cb)
+			return true
+		default:
+		}
+	case SourceTimer:
+		select {
 		case t := <-s.dispatcher.ChanTimer:
-			t.Cb()
+			s.execTimer(t)
+			return true
+		default:
+		}
+	case SourceCommand:
+		select {
+		case ci := <-s.commandServer.ChanCall:
+			s.execCommand(ci)
+			return true
+		default:
+		}
+	case SourceTick:
+		select {
+		case <-s.chanTick:
+			s.execTick()
+			return true
+		default:
+		}
+	case SourcePost:
+		select {
+		case <-s.chanPost:
+			s.execPost()
+			return true
+		default:
 		}
 	}
+	return false
+}
+
+func (s *Skeleton) execCall(ci *chanrpc.CallInfo) {
+	t := s.stats.begin()
+	s.server.Exec(ci)
+	s.stats.end(SourceCall, t)
+}
+
+func (s *Skeleton) execAsynRet(ri *chanrpc.RetInfo) {
+	t := s.stats.begin()
+	s.client.Cb(ri)
+	s.stats.end(SourceAsynRet, t)
+}
+
+func (s *Skeleton) execGoCb(cb func()) {
+	t := s.stats.begin()
+	s.g.Cb(cb)
+	s.stats.end(SourceGoCb, t)
+}
+
+func (s *Skeleton) execTimer(tm *timer.Timer) {
+	t := s.stats.begin()
+	tm.Cb()
+	s.stats.end(SourceTimer, t)
+}
+
+func (s *Skeleton) execCommand(ci *chanrpc.CallInfo) {
+	t := s.stats.begin()
+	s.commandServer.Exec(ci)
+	s.stats.end(SourceCommand, t)
+}
+
+func (s *Skeleton) execTick() {
+	t := s.stats.begin()
+	// a loop may be stopped or added by a tick
+	for _, l := range append([]*TickLoop(nil), s.ticks...) {
+		l.poll()
+	}
+	s.stats.end(SourceTick, t)
+}
+
+func (s *Skeleton) execPost() {
+	t := s.stats.begin()
+
+	s.mutexPost.Lock()
+	posts := s.posts
+	s.posts = nil
+	s.mutexPost.Unlock()
+
+	for _, f := range posts {
+		execFunc(f)
+	}
+	s.stats.endN(SourcePost, t, len(posts))
+}
+
+func execFunc(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("%v: %s", r, buf[:l])
+			} else {
+				log.Errorf("%v", r)
+			}
+		}
+	}()
+
+	f()
+}
+
+func (s *Skeleton) close() {
+	s.dispatcher.Close()
+	s.commandServer.Close()
+	s.server.Close()
+	for !s.g.Idle() || !s.client.Idle() {
+		s.g.Close()
+		s.client.Close()
+	}
+}
+
+// goroutine safe
+func (s *Skeleton) Stats() *Stats {
+	st := new(Stats)
+	st.Name = s.Name
+	st.Fair = s.Fair
+	for src := Source(0); src < numSource; src++ {
+		st.Sources[src].Count = atomic.LoadUint64(&s.stats.count[src])
+	}
+	st.Sources[SourceCall].QueueLen = len(s.server.ChanCall)
+	st.Sources[SourceCall].QueueCap = cap(s.server.ChanCall)
+	st.Sources[SourceAsynRet].QueueLen = len(s.client.ChanAsynRet)
+	st.Sources[SourceAsynRet].QueueCap = cap(s.client.ChanAsynRet)
+	st.Sources[SourceGoCb].QueueLen = len(s.g.ChanCb)
+	st.Sources[SourceGoCb].QueueCap = cap(s.g.ChanCb)
+	st.Sources[SourceTimer].QueueLen = len(s.dispatcher.ChanTimer)
+	st.Sources[SourceTimer].QueueCap = cap(s.dispatcher.ChanTimer)
+	st.Sources[SourceCommand].QueueLen = len(s.commandServer.ChanCall)
+	st.Sources[SourceCommand].QueueCap = cap(s.commandServer.ChanCall)
+	st.Sources[SourceTick].QueueLen = len(s.chanTick)
+	st.Sources[SourceTick].QueueCap = cap(s.chanTick)
+	s.mutexPost.Lock()
+	st.Sources[SourcePost].QueueLen = len(s.posts)
+	s.mutexPost.Unlock()
+	st.Busy = time.Duration(atomic.LoadInt64(&s.stats.busy))
+	st.Idle = time.Duration(atomic.LoadInt64(&s.stats.idle))
+	return st
 }
 
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
@@ -76,6 +320,28 @@ func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
+func (s *Skeleton) Every(interval time.Duration, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.Every(interval, cb)
+}
+
+func (s *Skeleton) Repeat(interval time.Duration, mode timer.RepeatMode, count int, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.Repeat(interval, mode, count, cb)
+}
+
+// with a timer.FakeClock, the timers can be driven by the test goroutine
+// instead of Run
+func (s *Skeleton) Dispatcher() *timer.Dispatcher {
+	return s.dispatcher
+}
+
 func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	if s.TimerDispatcherLen == 0 {
 		panic("invalid TimerDispatcherLen")
@@ -84,6 +350,19 @@ func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
+// the jobs run on the skeleton goroutine, the store is called by the Go of
+// the skeleton, call Start after registering them
+func (s *Skeleton) NewScheduler(store timer.JobStore) *timer.Scheduler {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return timer.NewScheduler(s.dispatcher, s.g, store)
+}
+
 func (s *Skeleton) Go(f func(), cb func()) {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
@@ -92,6 +371,28 @@ func (s *Skeleton) Go(f func(), cb func()) {
 	s.g.Go(f, cb)
 }
 
+// cb gets context.Canceled when the handle or ctx is cancelled before cb runs
+func (s *Skeleton) GoCtx(ctx context.Context, f func(ctx context.Context), cb func(err error)) *g.Handle {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.GoCtx(ctx, f, cb)
+}
+
+// f runs on the named pool, cb runs on the skeleton goroutine
+func (s *Skeleton) GoPool(pool string, f func(), cb func()) error {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	p := g.GetPool(pool)
+	if p == nil {
+		return fmt.Errorf("pool %v not found", pool)
+	}
+	return s.g.GoPool(p, f, cb)
+}
+
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
@@ -100,6 +401,27 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+func (s *Skeleton) NewKeyedContext(limit int) *g.KeyedContext {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.NewKeyedContext(limit)
+}
+
+// f runs on the skeleton goroutine, the queue is unbounded
+// goroutine safe
+func (s *Skeleton) Post(f func()) {
+	s.mutexPost.Lock()
+	s.posts = append(s.posts, f)
+	s.mutexPost.Unlock()
+
+	select {
+	case s.chanPost <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...interface{}) {
 	if s.AsynCallLen == 0 {
 		panic("invalid AsynCallLen")
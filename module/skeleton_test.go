@@ -0,0 +1,96 @@
+package module
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/chanrpc"
+	"github.com/hongjie104/leaf/timer"
+)
+
+func TestSkeletonFair(t *testing.T) {
+	observeLog()
+	clock := timer.NewFakeClock(time.Unix(0, 0))
+	s := &Skeleton{
+		GoLen:              10,
+		TimerDispatcherLen: 10,
+		ChanRPCServer:      chanrpc.NewServer(10),
+		Clock:              clock,
+		Fair:               true,
+		Weights:            map[Source]int{SourceCall: 2, SourceTimer: 3},
+	}
+	s.Init()
+
+	var got []Source
+	done := make(chan struct{})
+	record := func(src Source) {
+		got = append(got, src)
+		if len(got) == 12 {
+			close(done)
+		}
+	}
+
+	// 4 events queued on each source before the loop runs
+	s.RegisterChanRPC("call", func(args []interface{}) {
+		record(SourceCall)
+	})
+	for i := 0; i < 4; i++ {
+		s.ChanRPCServer.Go("call")
+		s.Go(func() {}, func() {
+			record(SourceGoCb)
+		})
+		s.AfterFunc(time.Second, func() {
+			record(SourceTimer)
+		})
+	}
+	clock.Advance(time.Second)
+	for deadline := time.Now().Add(5 * time.Second); len(s.g.ChanCb) < 4; runtime.Gosched() {
+		if time.Now().After(deadline) {
+			t.Fatal("Go callbacks not queued")
+		}
+	}
+
+	st := s.Stats()
+	for _, src := range []Source{SourceCall, SourceGoCb, SourceTimer} {
+		if st.Sources[src].QueueLen != 4 || st.Sources[src].QueueCap != 10 {
+			t.Fatalf("%v queue %v/%v, want 4/10", src, st.Sources[src].QueueLen, st.Sources[src].QueueCap)
+		}
+	}
+
+	closeSig := make(chan bool)
+	closed := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(closed)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not served")
+	}
+	closeSig <- true
+	<-closed
+
+	// drained in rounds by weight, the sources in order
+	want := []Source{
+		SourceCall, SourceCall, SourceGoCb, SourceTimer, SourceTimer, SourceTimer,
+		SourceCall, SourceCall, SourceGoCb, SourceTimer,
+		SourceGoCb,
+		SourceGoCb,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("served %v, want %v", got, want)
+	}
+
+	st = s.Stats()
+	if !st.Fair {
+		t.Fatal("not fair")
+	}
+	for _, src := range []Source{SourceCall, SourceGoCb, SourceTimer} {
+		if st.Sources[src].Count != 4 || st.Sources[src].QueueLen != 0 {
+			t.Fatalf("%v count %v queue %v, want 4 0", src, st.Sources[src].Count, st.Sources[src].QueueLen)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongjie104/leaf/console"
+	"github.com/hongjie104/leaf/log"
+)
+
+// Source identifies a channel served by the skeleton loop
+type Source int
+
+const (
+	SourceCall Source = iota
+	SourceAsynRet
+	SourceGoCb
+	SourceTimer
+	SourceCommand
+	SourceTick
+	SourcePost
+	numSource
+)
+
+var sourceNames = [numSource]string{
+	"call",
+	"asynret",
+	"gocb",
+	"timer",
+	"command",
+	"tick",
+	"post",
+}
+
+func (src Source) String() string {
+	if src < 0 || src >= numSource {
+		return fmt.Sprintf("source(%d)", int(src))
+	}
+	return sourceNames[src]
+}
+
+// updated by the skeleton goroutine, read by anyone
+type stats struct {
+	count [numSource]uint64
+	busy  int64
+	idle  int64
+	last  int64
+}
+
+func (st *stats) reset(now time.Time) {
+	atomic.StoreInt64(&st.last, now.UnixNano())
+}
+
+// called when an event has been received, returns the start of the busy period
+func (st *stats) begin() int64 {
+	now := time.Now().UnixNano()
+	atomic.AddInt64(&st.idle, now-atomic.LoadInt64(&st.last))
+	return now
+}
+
+func (st *stats) end(src Source, begin int64) {
+	st.endN(src, begin, 1)
+}
+
+func (st *stats) endN(src Source, begin int64, n int) {
+	now := time.Now().UnixNano()
+	atomic.AddUint64(&st.count[src], uint64(n))
+	atomic.AddInt64(&st.busy, now-begin)
+	atomic.StoreInt64(&st.last, now)
+}
+
+// SourceStats is a snapshot of one source
+type SourceStats struct {
+	Count    uint64
+	QueueLen int
+	QueueCap int
+}
+
+// Stats is a snapshot of the skeleton loop
+type Stats struct {
+	Name    string
+	Fair    bool
+	Sources [numSource]SourceStats
+	Busy    time.Duration
+	Idle    time.Duration
+}
+
+func (st *Stats) String() string {
+	var b strings.Builder
+	name := st.Name
+	if name == "" {
+		name = "-"
+	}
+	load := 0.0
+	if st.Busy+st.Idle > 0 {
+		load = float64(st.Busy) / float64(st.Busy+st.Idle) * 100
+	}
+	fmt.Fprintf(&b, "%v: fair=%v busy=%v idle=%v load=%.2f%%", name, st.Fair, st.Busy, st.Idle, load)
+	for src, ss := range st.Sources {
+		fmt.Fprintf(&b, "\r\n  %-8v count=%v queue=%v/%v", Source(src), ss.Count, ss.QueueLen, ss.QueueCap)
+	}
+	return b.String()
+}
+
+// named skeletons, shown by the console command "skeleton"
+var (
+	skeletons      = make(map[string]*Skeleton)
+	mutexSkeletons sync.Mutex
+	onceCommand    sync.Once
+)
+
+func registerSkeleton(s *Skeleton) {
+	mutexSkeletons.Lock()
+	defer mutexSkeletons.Unlock()
+
+	if _, ok := skeletons[s.Name]; ok {
+		log.Fatalf("skeleton %v is already registered", s.Name)
+	}
+	skeletons[s.Name] = s
+
+	onceCommand.Do(func() {
+		console.RegisterFunc("skeleton", "statistics of the skeleton loops", commandSkeleton)
+	})
+}
+
+func commandSkeleton(args []string) string {
+	mutexSkeletons.Lock()
+	defer mutexSkeletons.Unlock()
+
+	var names []string
+	if len(args) == 0 {
+		for name := range skeletons {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		names = args
+	}
+
+	var output []string
+	for _, name := range names {
+		s, ok := skeletons[name]
+		if !ok {
+			output = append(output, fmt.Sprintf("%v: skeleton not found", name))
+			continue
+		}
+		output = append(output, s.Stats().String())
+	}
+	return strings.Join(output, "\r\n")
+}
@@ -0,0 +1,210 @@
+package module
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/hongjie104/leaf/conf"
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/timer"
+)
+
+// fixed-rate update loop running on the skeleton goroutine
+// one tick loop per skeleton goroutine (goroutine not safe)
+type TickLoop struct {
+	// ticks run back to back after a stall, the rest are dropped, 0 means no limit
+	MaxCatchUp int
+	// log a warning when a tick takes longer than the interval
+	WarnOverrun bool
+	s           *Skeleton
+	interval    time.Duration
+	cb          func(dt time.Duration)
+	t           timer.ClockTimer
+	gen         uint64
+	// gen + 1 of the last fire, atomic
+	fired   uint64
+	base    time.Time
+	ticks   int64
+	paused  bool
+	stopped bool
+	stats   TickStats
+}
+
+type TickStats struct {
+	Ticks    uint64
+	Dropped  uint64
+	Overruns uint64
+	Last     time.Duration
+	Max      time.Duration
+	Total    time.Duration
+}
+
+func (st *TickStats) Avg() time.Duration {
+	if st.Ticks == 0 {
+		return 0
+	}
+	return st.Total / time.Duration(st.Ticks)
+}
+
+// cb is called rate times per second with the fixed step dt
+func (s *Skeleton) TickFunc(rate int, cb func(dt time.Duration)) *TickLoop {
+	if rate <= 0 || time.Duration(rate) > time.Second {
+		panic("invalid tick rate")
+	}
+
+	l := new(TickLoop)
+	l.MaxCatchUp = 5
+	l.WarnOverrun = true
+	l.s = s
+	l.interval = time.Second / time.Duration(rate)
+	l.cb = cb
+	l.start()
+	s.ticks = append(s.ticks, l)
+	return l
+}
+
+func (l *TickLoop) start() {
+	l.base = l.s.clock.Now()
+	l.ticks = 0
+	l.arm()
+}
+
+// the deadlines are derived from the base time, so the loop does not drift
+// the fire never blocks, the clock may be advanced on the skeleton goroutine,
+// and the fires missed meanwhile are caught up by run
+func (l *TickLoop) arm() {
+	next := l.base.Add(time.Duration(l.ticks+1) * l.interval)
+	gen := l.gen
+	s := l.s
+	l.t = s.clock.AfterFunc(next.Sub(s.clock.Now()), func() {
+		// a late fire of an old generation never hides a newer one
+		for {
+			fired := atomic.LoadUint64(&l.fired)
+			if fired > gen || atomic.CompareAndSwapUint64(&l.fired, fired, gen+1) {
+				break
+			}
+		}
+		select {
+		case s.chanTick <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// run the loop if it has fired since the last run
+func (l *TickLoop) poll() {
+	if fired := atomic.SwapUint64(&l.fired, 0); fired == l.gen+1 {
+		l.run(l.gen)
+	}
+}
+
+func (l *TickLoop) disarm() {
+	if l.t != nil {
+		l.t.Stop()
+		l.t = nil
+	}
+	l.gen++
+}
+
+func (l *TickLoop) run(gen uint64) {
+	if gen != l.gen {
+		return
+	}
+
+	due := int64(l.s.clock.Now().Sub(l.base)/l.interval) - l.ticks
+	if l.MaxCatchUp > 0 && due > int64(l.MaxCatchUp) {
+		dropped := due - int64(l.MaxCatchUp)
+		l.ticks += dropped
+		l.stats.Dropped += uint64(dropped)
+		due = int64(l.MaxCatchUp)
+		log.Warnf("tick loop %v: %v ticks behind, dropped %v", l.interval, dropped+due, dropped)
+	}
+
+	for i := int64(0); i < due; i++ {
+		begin := time.Now()
+		l.exec()
+		elapsed := time.Since(begin)
+
+		l.ticks++
+		l.stats.Ticks++
+		l.stats.Last = elapsed
+		l.stats.Total += elapsed
+		if elapsed > l.stats.Max {
+			l.stats.Max = elapsed
+		}
+		if elapsed > l.interval {
+			l.stats.Overruns++
+			if l.WarnOverrun {
+				log.Warnf("tick loop %v: tick overrun, took %v", l.interval, elapsed)
+			}
+		}
+
+		// paused or stopped by the callback
+		if gen != l.gen {
+			return
+		}
+	}
+
+	l.arm()
+}
+
+func (l *TickLoop) exec() {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("%v: %s", r, buf[:l])
+			} else {
+				log.Errorf("%v", r)
+			}
+		}
+	}()
+
+	l.cb(l.interval)
+}
+
+func (l *TickLoop) Interval() time.Duration {
+	return l.interval
+}
+
+// the time spent paused is not caught up on resume
+func (l *TickLoop) Pause() {
+	if l.paused || l.stopped {
+		return
+	}
+	l.paused = true
+	l.disarm()
+}
+
+func (l *TickLoop) Resume() {
+	if !l.paused || l.stopped {
+		return
+	}
+	l.paused = false
+	l.start()
+}
+
+func (l *TickLoop) Paused() bool {
+	return l.paused
+}
+
+func (l *TickLoop) Stop() {
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	l.disarm()
+
+	for i, t := range l.s.ticks {
+		if t == l {
+			l.s.ticks = append(l.s.ticks[:i], l.s.ticks[i+1:]...)
+			break
+		}
+	}
+}
+
+func (l *TickLoop) Stats() TickStats {
+	return l.stats
+}
@@ -0,0 +1,231 @@
+package module
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/timer"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// the test goroutine plays the skeleton goroutine
+func newTickSkeleton() (*Skeleton, *timer.FakeClock) {
+	clock := timer.NewFakeClock(time.Unix(0, 0))
+	s := &Skeleton{Clock: clock}
+	s.Init()
+	return s, clock
+}
+
+// advance the clock and handle the tick wake-up, if any
+func step(s *Skeleton, clock *timer.FakeClock, d time.Duration) {
+	clock.Advance(d)
+	select {
+	case <-s.chanTick:
+		s.execTick()
+	default:
+	}
+}
+
+func observeLog() *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.WarnLevel)
+	log.Logger = zap.New(core).Sugar()
+	return logs
+}
+
+func TestTickFunc(t *testing.T) {
+	observeLog()
+	s, clock := newTickSkeleton()
+
+	n := 0
+	var last time.Duration
+	l := s.TickFunc(10, func(dt time.Duration) {
+		n++
+		last = dt
+	})
+
+	step(s, clock, 50*time.Millisecond)
+	if n != 0 {
+		t.Fatalf("ticks before the interval: %v", n)
+	}
+	for i := 1; i <= 3; i++ {
+		step(s, clock, 100*time.Millisecond)
+		if n != i {
+			t.Fatalf("ticks: %v, want %v", n, i)
+		}
+	}
+	if last != 100*time.Millisecond || l.Interval() != last {
+		t.Fatalf("dt: %v", last)
+	}
+	if st := l.Stats(); st.Ticks != 3 || st.Dropped != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestTickMaxCatchUp(t *testing.T) {
+	logs := observeLog()
+	s, clock := newTickSkeleton()
+
+	n := 0
+	l := s.TickFunc(10, func(dt time.Duration) {
+		n++
+	})
+	l.MaxCatchUp = 5
+	l.WarnOverrun = false
+
+	// the fires missed during a stall coalesce into one wake-up
+	step(s, clock, time.Second)
+	if n != 5 {
+		t.Fatalf("ticks: %v, want 5", n)
+	}
+	if st := l.Stats(); st.Ticks != 5 || st.Dropped != 5 {
+		t.Fatalf("stats: %+v", st)
+	}
+	if logs.FilterMessageSnippet("dropped 5").Len() != 1 {
+		t.Fatalf("missing drop warning: %v", logs.All())
+	}
+
+	// back on schedule
+	step(s, clock, 100*time.Millisecond)
+	if n != 6 {
+		t.Fatalf("ticks: %v, want 6", n)
+	}
+}
+
+func TestTickOverrun(t *testing.T) {
+	logs := observeLog()
+	s, clock := newTickSkeleton()
+
+	l := s.TickFunc(1000, func(dt time.Duration) {
+		time.Sleep(2 * dt)
+	})
+	step(s, clock, time.Millisecond)
+	if st := l.Stats(); st.Ticks != 1 || st.Overruns != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+	if logs.FilterMessageSnippet("overrun").Len() != 1 {
+		t.Fatalf("missing overrun warning: %v", logs.All())
+	}
+
+	l.WarnOverrun = false
+	step(s, clock, time.Millisecond)
+	if st := l.Stats(); st.Overruns != 2 {
+		t.Fatalf("stats: %+v", st)
+	}
+	if logs.FilterMessageSnippet("overrun").Len() != 1 {
+		t.Fatalf("unexpected overrun warning: %v", logs.All())
+	}
+}
+
+func TestTickPauseResume(t *testing.T) {
+	observeLog()
+	s, clock := newTickSkeleton()
+
+	n := 0
+	l := s.TickFunc(10, func(dt time.Duration) {
+		n++
+	})
+	step(s, clock, 100*time.Millisecond)
+
+	l.Pause()
+	if !l.Paused() || clock.Len() != 0 {
+		t.Fatalf("paused: %v, timers: %v", l.Paused(), clock.Len())
+	}
+	step(s, clock, time.Second)
+	if n != 1 {
+		t.Fatalf("ticks while paused: %v", n)
+	}
+
+	// the time spent paused is not caught up
+	l.Resume()
+	step(s, clock, 100*time.Millisecond)
+	if n != 2 {
+		t.Fatalf("ticks: %v, want 2", n)
+	}
+	if st := l.Stats(); st.Dropped != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestTickStop(t *testing.T) {
+	observeLog()
+	s, clock := newTickSkeleton()
+
+	n := 0
+	var l *TickLoop
+	l = s.TickFunc(10, func(dt time.Duration) {
+		n++
+		if n == 2 {
+			l.Stop()
+		}
+	})
+	other := s.TickFunc(10, func(dt time.Duration) {})
+
+	// stopped by its own callback in the middle of a catch-up
+	step(s, clock, time.Second)
+	if n != 2 {
+		t.Fatalf("ticks: %v, want 2", n)
+	}
+	step(s, clock, time.Second)
+	if n != 2 {
+		t.Fatalf("ticks after stop: %v", n)
+	}
+	if len(s.ticks) != 1 || s.ticks[0] != other {
+		t.Fatalf("tick loops: %v", len(s.ticks))
+	}
+
+	other.Stop()
+	if len(s.ticks) != 0 || clock.Len() != 0 {
+		t.Fatalf("tick loops: %v, timers: %v", len(s.ticks), clock.Len())
+	}
+}
+
+func TestTickAdvanceOnSkeleton(t *testing.T) {
+	logs := observeLog()
+	s, clock := newTickSkeleton()
+
+	ticked := make(chan int, 16)
+	n := 0
+	s.TickFunc(10, func(dt time.Duration) {
+		n++
+		ticked <- n
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+
+	// the fires must not block the skeleton goroutine
+	for want := 1; want <= 6; {
+		s.Post(func() {
+			clock.Advance(100 * time.Millisecond)
+			clock.Advance(100 * time.Millisecond)
+		})
+		for i := 0; i < 2; i++ {
+			select {
+			case got := <-ticked:
+				if got != want {
+					t.Fatalf("tick %v, want %v", got, want)
+				}
+				want++
+			case <-time.After(5 * time.Second):
+				t.Fatal("tick loop deadlocked")
+			}
+		}
+	}
+
+	closeSig <- true
+	<-done
+
+	for _, e := range logs.All() {
+		if strings.Contains(e.Message, "dropped") {
+			t.Fatalf("unexpected drop: %v", e.Message)
+		}
+	}
+}
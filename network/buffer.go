@@ -0,0 +1,62 @@
+package network
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// buffers bucketed by length, each bucket holds the buffers of 2^n bytes,
+// from minSize to maxSize, the larger ones are not pooled
+// goroutine safe
+type BufferPool struct {
+	minShift int
+	pools    []sync.Pool
+}
+
+// the sizes are rounded up to powers of 2
+func NewBufferPool(minSize int, maxSize int) *BufferPool {
+	if minSize <= 0 || maxSize < minSize {
+		panic("invalid buffer size")
+	}
+
+	p := new(BufferPool)
+	p.minShift = bits.Len(uint(minSize - 1))
+	p.pools = make([]sync.Pool, bits.Len(uint(maxSize-1))-p.minShift+1)
+	return p
+}
+
+func (p *BufferPool) bucket(n int) int {
+	if n <= 1<<p.minShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - p.minShift
+}
+
+// len n
+func (p *BufferPool) Get(n int) []byte {
+	i := p.bucket(n)
+	if i >= len(p.pools) {
+		return make([]byte, n)
+	}
+	if b, ok := p.pools[i].Get().([]byte); ok {
+		return b[:n]
+	}
+	return make([]byte, n, 1<<(p.minShift+i))
+}
+
+// b must not be used after the call
+func (p *BufferPool) Put(b []byte) {
+	c := cap(b)
+	if c < 1<<p.minShift || c&(c-1) != 0 {
+		return
+	}
+	if i := p.bucket(c); i < len(p.pools) {
+		p.pools[i].Put(b[:0])
+	}
+}
+
+// the outbound messages, from 64 B to 64 KB
+var writeBufferPool = NewBufferPool(64, 64*1024)
+
+// the write buffers of the websocket connections are only held while writing
+var wsWriteBufferPool = new(sync.Pool)
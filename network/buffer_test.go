@@ -0,0 +1,23 @@
+package network
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	p := NewBufferPool(100, 1000)
+	for _, c := range []struct {
+		n   int
+		cap int
+	}{
+		{1, 128},
+		{128, 128},
+		{129, 256},
+		{1000, 1024},
+		{1025, 1025},
+	} {
+		b := p.Get(c.n)
+		if len(b) != c.n || cap(b) != c.cap {
+			t.Fatalf("get %v: len %v cap %v, want cap %v", c.n, len(b), cap(b), c.cap)
+		}
+		p.Put(b)
+	}
+}
@@ -0,0 +1,203 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// a lightweight session cipher for the clients without TLS
+// on the first read or write, the client then the server send an ephemeral
+// P-256 public key, the ECDH secret gives one AES-256-GCM key per direction,
+// then each write is sealed in records
+// ------------------------------
+// | len | ciphertext and tag |
+// ------------------------------
+// |  2  |                    |
+//
+// the nonce is the sequence number of the record, the peer is not
+// authenticated, so the cipher only stands against passive eavesdroppers
+type CipherConn struct {
+	net.Conn
+	client         bool
+	handshaked     int32
+	mutexHandshake sync.Mutex
+	handshakeErr   error
+	readAEAD       cipher.AEAD
+	readSeq        uint64
+	readBuf        []byte
+	writeAEAD      cipher.AEAD
+	writeSeq       uint64
+	mutexWrite     sync.Mutex
+}
+
+const (
+	cipherPublicKeyLen = 65
+	maxCipherRecord    = 16 * 1024
+)
+
+var (
+	labelClientWrite = []byte("leaf client write")
+	labelServerWrite = []byte("leaf server write")
+)
+
+func NewCipherServer(conn net.Conn) *CipherConn {
+	return &CipherConn{Conn: conn}
+}
+
+func NewCipherClient(conn net.Conn) *CipherConn {
+	return &CipherConn{Conn: conn, client: true}
+}
+
+// done once, called by the first read or write
+// goroutine safe
+func (c *CipherConn) Handshake() error {
+	if atomic.LoadInt32(&c.handshaked) == 1 {
+		return c.handshakeErr
+	}
+
+	c.mutexHandshake.Lock()
+	defer c.mutexHandshake.Unlock()
+	if c.handshaked == 0 {
+		c.handshakeErr = c.handshake()
+		atomic.StoreInt32(&c.handshaked, 1)
+	}
+	return c.handshakeErr
+}
+
+func (c *CipherConn) handshake() error {
+	curve := ecdh.P256()
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	pub := priv.PublicKey().Bytes()
+
+	// the client writes first
+	peer := make([]byte, cipherPublicKeyLen)
+	if c.client {
+		if _, err := c.Conn.Write(pub); err != nil {
+			return err
+		}
+	}
+	if _, err := io.ReadFull(c.Conn, peer); err != nil {
+		return err
+	}
+	if !c.client {
+		if _, err := c.Conn.Write(pub); err != nil {
+			return err
+		}
+	}
+	peerKey, err := curve.NewPublicKey(peer)
+	if err != nil {
+		return errors.New("invalid public key")
+	}
+	secret, err := priv.ECDH(peerKey)
+	if err != nil {
+		return err
+	}
+
+	// both keys depend on the two public keys
+	clientPub, serverPub := pub, peer
+	if !c.client {
+		clientPub, serverPub = peer, pub
+	}
+	clientWrite, err := newCipherAEAD(secret, labelClientWrite, clientPub, serverPub)
+	if err != nil {
+		return err
+	}
+	serverWrite, err := newCipherAEAD(secret, labelServerWrite, clientPub, serverPub)
+	if err != nil {
+		return err
+	}
+
+	if c.client {
+		c.readAEAD, c.writeAEAD = serverWrite, clientWrite
+	} else {
+		c.readAEAD, c.writeAEAD = clientWrite, serverWrite
+	}
+	return nil
+}
+
+func newCipherAEAD(secret []byte, label []byte, clientPub []byte, serverPub []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(label)
+	mac.Write(clientPub)
+	mac.Write(serverPub)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func cipherNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// goroutine not safe
+func (c *CipherConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	if len(c.readBuf) == 0 {
+		var l [2]byte
+		if _, err := io.ReadFull(c.Conn, l[:]); err != nil {
+			return 0, err
+		}
+		record := make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(c.Conn, record); err != nil {
+			return 0, err
+		}
+		data, err := c.readAEAD.Open(record[:0], cipherNonce(c.readSeq), record, nil)
+		if err != nil {
+			return 0, err
+		}
+		c.readSeq++
+		c.readBuf = data
+	}
+
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// goroutine safe
+func (c *CipherConn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+
+	var n int
+	for n < len(b) {
+		chunk := b[n:]
+		if len(chunk) > maxCipherRecord {
+			chunk = chunk[:maxCipherRecord]
+		}
+
+		record := make([]byte, 2, 2+len(chunk)+c.writeAEAD.Overhead())
+		record = c.writeAEAD.Seal(record, cipherNonce(c.writeSeq), chunk, nil)
+		binary.BigEndian.PutUint16(record, uint16(len(record)-2))
+		c.writeSeq++
+		if _, err := c.Conn.Write(record); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestCipherConn(t *testing.T) {
+	s, c := net.Pipe()
+	server := NewCipherServer(s)
+	client := NewCipherClient(c)
+	defer server.Close()
+	defer client.Close()
+
+	big := bytes.Repeat([]byte("leaf"), 10000)
+	go func() {
+		client.Write([]byte("hello"))
+		client.Write(big)
+	}()
+
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(server, b); err != nil || string(b) != "hello" {
+		t.Fatalf("read %q: %v", b, err)
+	}
+	b = make([]byte, len(big))
+	if _, err := io.ReadFull(server, b); err != nil || !bytes.Equal(b, big) {
+		t.Fatalf("read %v bytes: %v", len(b), err)
+	}
+
+	msgParser := NewMsgParser()
+	w := newTCPConn(server, 10, msgParser, 1024, 0)
+	r := newTCPConn(client, 10, msgParser, 1024, 0)
+	w.WriteMsg([]byte("a"))
+	w.WriteMsg([]byte("b"))
+	for _, want := range []string{"a", "b"} {
+		if b, err := r.ReadMsg(); err != nil || string(b) != want {
+			t.Fatalf("read msg %q: %v, want %q", b, err, want)
+		}
+	}
+}
+
+// a record modified on the wire is rejected
+func TestCipherConnTampered(t *testing.T) {
+	s, c := net.Pipe()
+	mitm, peer := net.Pipe()
+	server := NewCipherServer(s)
+	client := NewCipherClient(peer)
+	defer server.Close()
+	defer client.Close()
+
+	go io.Copy(mitm, c)
+	go func() {
+		b := make([]byte, 1024)
+		for i := 0; ; i++ {
+			n, err := mitm.Read(b)
+			if err != nil {
+				return
+			}
+			// the public key is first
+			if i > 0 {
+				b[n-1] ^= 1
+			}
+			c.Write(b[:n])
+		}
+	}()
+	go client.Write([]byte("hello"))
+
+	if _, err := server.Read(make([]byte, 5)); err == nil {
+		t.Fatal("tampered record read")
+	}
+}
@@ -0,0 +1,282 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/hongjie104/leaf/log"
+)
+
+// splits the stream of a TCPConn into messages, MsgParser is the length
+// prefix codec
+type FrameCodec interface {
+	// goroutine safe
+	Read(conn *TCPConn) ([]byte, error)
+	// goroutine safe
+	Write(conn *TCPConn, args ...[]byte) error
+}
+
+// the codecs reading into a BufferPool, as MsgParser, VarintCodec and
+// HeaderCodec
+type pooledCodec interface {
+	SetBufferPool(pool *BufferPool)
+}
+
+// ReadBufferPool of TCPServer and TCPClient
+func setReadBufferPool(codec FrameCodec, pool *BufferPool) {
+	if pool == nil {
+		return
+	}
+	if c, ok := codec.(pooledCodec); ok {
+		c.SetBufferPool(pool)
+		return
+	}
+	log.Infof("ReadBufferPool ignored, the FrameCodec reads into its own buffers")
+}
+
+// a buffer of n bytes, from pool if not nil
+func getReadBuffer(pool *BufferPool, n int) []byte {
+	if pool != nil {
+		return pool.Get(n)
+	}
+	return make([]byte, n)
+}
+
+func putReadBuffer(pool *BufferPool, b []byte) {
+	if pool != nil {
+		pool.Put(b)
+	}
+}
+
+func msgLen(args [][]byte) int {
+	var n int
+	for i := 0; i < len(args); i++ {
+		n += len(args[i])
+	}
+	return n
+}
+
+// ----------------------
+// | len uvarint | data |
+// ----------------------
+type VarintCodec struct {
+	maxMsgLen  uint32
+	bufferPool *BufferPool
+}
+
+// 4096 by default
+func NewVarintCodec(maxMsgLen uint32) *VarintCodec {
+	if maxMsgLen == 0 {
+		maxMsgLen = 4096
+	}
+	return &VarintCodec{maxMsgLen: maxMsgLen}
+}
+
+// the messages read are borrowed from pool, see TCPConn.ReleaseMsg
+// It's dangerous to call the method on reading or writing
+func (c *VarintCodec) SetBufferPool(pool *BufferPool) {
+	c.bufferPool = pool
+}
+
+// b is a message of Read, it must not be used after the call
+// goroutine safe
+func (c *VarintCodec) Release(b []byte) {
+	putReadBuffer(c.bufferPool, b)
+}
+
+// goroutine safe
+func (c *VarintCodec) Read(conn *TCPConn) ([]byte, error) {
+	n, err := binary.ReadUvarint(conn)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(c.maxMsgLen) {
+		return nil, errors.New("message too long")
+	} else if n == 0 {
+		return nil, errors.New("message too short")
+	}
+
+	msgData := getReadBuffer(c.bufferPool, int(n))
+	if _, err := io.ReadFull(conn, msgData); err != nil {
+		c.Release(msgData)
+		return nil, err
+	}
+	return msgData, nil
+}
+
+// goroutine safe
+func (c *VarintCodec) Write(conn *TCPConn, args ...[]byte) error {
+	n := msgLen(args)
+	if n > int(c.maxMsgLen) {
+		return errors.New("message too long")
+	} else if n == 0 {
+		return errors.New("message too short")
+	}
+
+	msg := writeBufferPool.Get(binary.MaxVarintLen32 + n)
+	l := binary.PutUvarint(msg, uint64(n))
+	for i := 0; i < len(args); i++ {
+		l += copy(msg[l:], args[i])
+	}
+
+	conn.writePooled(msg[:l])
+	return nil
+}
+
+// ----------------------------------------------
+// | magic | flags | len | crc32 of data | data |
+// ----------------------------------------------
+// |   2   |   1   |  4  |       4       |      |
+// big endian, the crc is IEEE
+type HeaderCodec struct {
+	magic      uint16
+	flags      byte
+	maxMsgLen  uint32
+	bufferPool *BufferPool
+}
+
+const headerCodecLen = 11
+
+// the frames are written with flags, the flags read are not checked
+// maxMsgLen is 4096 by default
+func NewHeaderCodec(magic uint16, flags byte, maxMsgLen uint32) *HeaderCodec {
+	if maxMsgLen == 0 {
+		maxMsgLen = 4096
+	}
+	return &HeaderCodec{magic: magic, flags: flags, maxMsgLen: maxMsgLen}
+}
+
+// the messages read are borrowed from pool, see TCPConn.ReleaseMsg
+// It's dangerous to call the method on reading or writing
+func (c *HeaderCodec) SetBufferPool(pool *BufferPool) {
+	c.bufferPool = pool
+}
+
+// b is a message of Read, it must not be used after the call
+// goroutine safe
+func (c *HeaderCodec) Release(b []byte) {
+	putReadBuffer(c.bufferPool, b)
+}
+
+// goroutine safe
+func (c *HeaderCodec) Read(conn *TCPConn) ([]byte, error) {
+	var header [headerCodecLen]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(header[:]) != c.magic {
+		return nil, errors.New("invalid magic number")
+	}
+	n := binary.BigEndian.Uint32(header[3:])
+	if n > c.maxMsgLen {
+		return nil, errors.New("message too long")
+	} else if n == 0 {
+		return nil, errors.New("message too short")
+	}
+
+	msgData := getReadBuffer(c.bufferPool, int(n))
+	if _, err := io.ReadFull(conn, msgData); err != nil {
+		c.Release(msgData)
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(msgData) != binary.BigEndian.Uint32(header[7:]) {
+		c.Release(msgData)
+		return nil, errors.New("invalid checksum")
+	}
+	return msgData, nil
+}
+
+// goroutine safe
+func (c *HeaderCodec) Write(conn *TCPConn, args ...[]byte) error {
+	n := msgLen(args)
+	if n > int(c.maxMsgLen) {
+		return errors.New("message too long")
+	} else if n == 0 {
+		return errors.New("message too short")
+	}
+
+	msg := writeBufferPool.Get(headerCodecLen + n)
+	binary.BigEndian.PutUint16(msg, c.magic)
+	msg[2] = c.flags
+	binary.BigEndian.PutUint32(msg[3:], uint32(n))
+	l := headerCodecLen
+	for i := 0; i < len(args); i++ {
+		l += copy(msg[l:], args[i])
+	}
+	binary.BigEndian.PutUint32(msg[7:], crc32.ChecksumIEEE(msg[headerCodecLen:]))
+
+	conn.writePooled(msg)
+	return nil
+}
+
+// -----------------
+// | data | delim |
+// -----------------
+// with '\n', a '\r' before the delimiter is dropped too
+type DelimiterCodec struct {
+	delim     byte
+	maxMsgLen uint32
+}
+
+// 4096 by default
+func NewDelimiterCodec(delim byte, maxMsgLen uint32) *DelimiterCodec {
+	if maxMsgLen == 0 {
+		maxMsgLen = 4096
+	}
+	return &DelimiterCodec{delim: delim, maxMsgLen: maxMsgLen}
+}
+
+// goroutine safe
+func (c *DelimiterCodec) Read(conn *TCPConn) ([]byte, error) {
+	r := conn.bufReader()
+	var msgData []byte
+	for {
+		b, err := r.ReadSlice(c.delim)
+		if len(msgData)+len(b) > int(c.maxMsgLen)+2 {
+			return nil, errors.New("message too long")
+		}
+		msgData = append(msgData, b...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+
+	msgData = msgData[:len(msgData)-1]
+	if c.delim == '\n' && len(msgData) > 0 && msgData[len(msgData)-1] == '\r' {
+		msgData = msgData[:len(msgData)-1]
+	}
+	if len(msgData) > int(c.maxMsgLen) {
+		return nil, errors.New("message too long")
+	}
+	return msgData, nil
+}
+
+// goroutine safe
+func (c *DelimiterCodec) Write(conn *TCPConn, args ...[]byte) error {
+	n := msgLen(args)
+	if n > int(c.maxMsgLen) {
+		return errors.New("message too long")
+	}
+	for i := 0; i < len(args); i++ {
+		if bytes.IndexByte(args[i], c.delim) >= 0 {
+			return errors.New("delimiter in message")
+		}
+	}
+
+	msg := writeBufferPool.Get(n + 1)
+	l := 0
+	for i := 0; i < len(args); i++ {
+		l += copy(msg[l:], args[i])
+	}
+	msg[l] = c.delim
+
+	conn.writePooled(msg)
+	return nil
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFrameCodec(t *testing.T) {
+	msgParser := NewMsgParser()
+	msgParser.SetMsgLen(2, 1, 1000)
+	for name, codec := range map[string]FrameCodec{
+		"length":    msgParser,
+		"varint":    NewVarintCodec(1000),
+		"header":    NewHeaderCodec(0xcafe, 1, 1000),
+		"delimiter": NewDelimiterCodec('\n', 1000),
+	} {
+		server, client := net.Pipe()
+		w := newTCPConn(server, 10, codec, 1024, 0)
+		r := newTCPConn(client, 1, codec, 1024, 0)
+
+		msgs := []string{"hello", "world", string(make([]byte, 900))}
+		if name == "delimiter" {
+			msgs[2] = "a\r"
+		}
+		for _, msg := range msgs {
+			if err := w.WriteMsg([]byte(msg[:1]), []byte(msg[1:])); err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+		}
+		if err := w.WriteMsg(make([]byte, 1001)); err == nil {
+			t.Fatalf("%v: message too long written", name)
+		}
+
+		for _, msg := range msgs {
+			if name == "delimiter" && msg == "a\r" {
+				msg = "a"
+			}
+			b, err := r.ReadMsg()
+			if err != nil || string(b) != msg {
+				t.Fatalf("%v: read %q: %v, want %q", name, b, err, msg)
+			}
+		}
+		w.Close()
+		r.Close()
+	}
+}
+
+func TestHeaderCodecChecksum(t *testing.T) {
+	server, client := net.Pipe()
+	r := newTCPConn(client, 1, NewHeaderCodec(0xcafe, 0, 0), 1, 0)
+	defer r.Close()
+
+	go func() {
+		server.Write([]byte{0xca, 0xfe, 0, 0, 0, 0, 2, 0, 0, 0, 0, 'h', 'i'})
+		server.Close()
+	}()
+	if _, err := r.ReadMsg(); err == nil || err.Error() != "invalid checksum" {
+		t.Fatalf("read: %v", err)
+	}
+}
+
+func TestFrameCodecPooled(t *testing.T) {
+	for name, codec := range map[string]FrameCodec{
+		"varint": NewVarintCodec(1000),
+		"header": NewHeaderCodec(0xcafe, 1, 1000),
+	} {
+		setReadBufferPool(codec, NewBufferPool(64, 1024))
+		server, client := net.Pipe()
+		w := newTCPConn(server, 10, codec, 1024, 0)
+		r := newTCPConn(client, 1, codec, 1024, 0)
+
+		for i := 0; i < 3; i++ {
+			if err := w.WriteMsg([]byte("abc")); err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			data, err := r.ReadMsg()
+			if err != nil || string(data) != "abc" {
+				t.Fatalf("%v: read %q: %v", name, data, err)
+			}
+			if cap(data) != 64 {
+				t.Fatalf("%v: cap %v, want 64", name, cap(data))
+			}
+			r.ReleaseMsg(data)
+		}
+		w.Close()
+		r.Close()
+	}
+}
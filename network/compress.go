@@ -0,0 +1,355 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goroutine safe
+type Compressor interface {
+	Compress(args [][]byte) ([]byte, error)
+	// maxLen is the longest data decompressed, the result is owned by the
+	// caller and may be given to the pool of the decompressed messages
+	Decompress(data []byte, maxLen int) ([]byte, error)
+}
+
+var (
+	compressors      = make(map[string]Compressor)
+	mutexCompressors sync.RWMutex
+)
+
+// only gzip and deflate are built in, zstd or snappy are registered by the
+// application with a Compressor wrapping their package
+func RegisterCompressor(name string, c Compressor) {
+	if name == "" || strings.Contains(name, ",") {
+		panic("invalid compressor name")
+	}
+
+	mutexCompressors.Lock()
+	defer mutexCompressors.Unlock()
+	compressors[name] = c
+}
+
+func getCompressor(name string) Compressor {
+	mutexCompressors.RLock()
+	defer mutexCompressors.RUnlock()
+	return compressors[name]
+}
+
+func init() {
+	RegisterCompressor("gzip", newFlateCompressor(func(w io.Writer) (flateWriter, error) {
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}))
+	RegisterCompressor("deflate", newFlateCompressor(func(w io.Writer) (flateWriter, error) {
+		return flate.NewWriter(w, flate.DefaultCompression)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	}))
+}
+
+type flateWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// the writers are pooled
+type flateCompressor struct {
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	writers   sync.Pool
+}
+
+func newFlateCompressor(newWriter func(w io.Writer) (flateWriter, error), newReader func(r io.Reader) (io.ReadCloser, error)) *flateCompressor {
+	c := new(flateCompressor)
+	c.newReader = newReader
+	c.writers.New = func() interface{} {
+		w, err := newWriter(nil)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	}
+	return c
+}
+
+func (c *flateCompressor) Compress(args [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.writers.Get().(flateWriter)
+	defer c.writers.Put(w)
+
+	w.Reset(&buf)
+	for i := 0; i < len(args); i++ {
+		if _, err := w.Write(args[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// the messages decompressed, from 64 B to 64 KB
+var decompressBufferPool = NewBufferPool(64, 64*1024)
+
+// the buffer is borrowed from decompressBufferPool
+func (c *flateCompressor) Decompress(data []byte, maxLen int) ([]byte, error) {
+	r, err := c.newReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b := decompressBufferPool.Get(minInt(2*len(data)+64, maxLen+1))
+	n := 0
+	for {
+		if n == len(b) {
+			if n > maxLen {
+				decompressBufferPool.Put(b)
+				return nil, errors.New("message too long")
+			}
+			nb := decompressBufferPool.Get(minInt(2*len(b), maxLen+1))
+			copy(nb, b)
+			decompressBufferPool.Put(b)
+			b = nb
+		}
+
+		m, err := r.Read(b[n:])
+		n += m
+		if err == io.EOF && n <= maxLen {
+			return b[:n], nil
+		}
+		if err == io.EOF {
+			err = errors.New("message too long")
+		}
+		if err != nil {
+			decompressBufferPool.Put(b)
+			return nil, err
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// the compression of the messages of a Conn, each message starts with flags
+// ----------------
+// | flags | data |
+// ----------------
+// |   1   |      |
+//
+// the client offers its Algorithms first, with flagNegotiate and the names
+// separated by commas, the server answers the one chosen, or none
+// before the answer, the messages are not compressed
+type Compression struct {
+	// the names of the registered compressors, by preference of the client,
+	// see RegisterCompressor
+	Algorithms []string
+	// the messages shorter are not compressed
+	Threshold int
+	// the longest message decompressed, 4096 by default
+	MaxMsgLen uint32
+}
+
+const (
+	flagCompressed = 0x01
+	flagNegotiate  = 0x80
+)
+
+var (
+	flagsNone       = []byte{0}
+	flagsCompressed = []byte{flagCompressed}
+)
+
+type CompressConn struct {
+	Conn
+	compression *Compression
+	client      bool
+	negotiated  bool
+	mutex       sync.Mutex
+	algorithm   string
+	compressor  Compressor
+	// the message of Conn returned uncompressed by ReadMsg
+	borrowed []byte
+}
+
+// the server side of conn
+func CompressServer(conn Conn, compression *Compression) *CompressConn {
+	return &CompressConn{Conn: conn, compression: compression}
+}
+
+// the client side of conn, the offer is written at once
+func CompressClient(conn Conn, compression *Compression) *CompressConn {
+	c := &CompressConn{Conn: conn, compression: compression, client: true}
+	c.Conn.WriteMsg([]byte{flagNegotiate}, []byte(strings.Join(compression.Algorithms, ",")))
+	return c
+}
+
+// the algorithm negotiated, empty if none
+func (c *CompressConn) Algorithm() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.algorithm
+}
+
+// goroutine not safe, the caller holds the lock
+func (c *CompressConn) setAlgorithm(name string) {
+	c.algorithm = name
+	c.compressor = getCompressor(name)
+}
+
+func (c *CompressConn) getCompressor() Compressor {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.compressor
+}
+
+// goroutine not safe
+func (c *CompressConn) ReadMsg() ([]byte, error) {
+	for {
+		data, err := c.Conn.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < 1 {
+			return nil, errors.New("message too short")
+		}
+
+		flags := data[0]
+		if flags&flagNegotiate != 0 {
+			err := c.negotiate(data[1:])
+			c.release(data)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c.negotiated = true
+		if flags&flagCompressed == 0 {
+			c.borrowed = data
+			return data[1:], nil
+		}
+
+		compressor := c.getCompressor()
+		if compressor == nil {
+			c.release(data)
+			return nil, errors.New("compressed message not negotiated")
+		}
+		maxMsgLen := c.compression.MaxMsgLen
+		if maxMsgLen == 0 {
+			maxMsgLen = 4096
+		}
+		b, err := compressor.Decompress(data[1:], int(maxMsgLen))
+		c.release(data)
+		return b, err
+	}
+}
+
+// an uncompressed message is given back to the Conn, a decompressed one to
+// the pool of the decompressed messages
+func (c *CompressConn) ReleaseMsg(b []byte) {
+	if sameArray(b, c.borrowed) {
+		c.release(c.borrowed)
+		c.borrowed = nil
+		return
+	}
+	decompressBufferPool.Put(b)
+}
+
+func (c *CompressConn) release(data []byte) {
+	if r, ok := c.Conn.(MsgReleaser); ok {
+		r.ReleaseMsg(data)
+	}
+}
+
+// b is a slice of a, both end at the end of the array
+func sameArray(b []byte, a []byte) bool {
+	return cap(a) > 0 && cap(b) > 0 && &a[:cap(a)][cap(a)-1] == &b[:cap(b)][cap(b)-1]
+}
+
+func (c *CompressConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.Conn.(ReadDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errors.New("read deadline not supported")
+}
+
+// -1 if the wrapped Conn has no WriteQueue
+func (c *CompressConn) WriteQueueFree() int {
+	if q, ok := c.Conn.(WriteQueue); ok {
+		return q.WriteQueueFree()
+	}
+	return -1
+}
+
+func (c *CompressConn) negotiate(payload []byte) error {
+	if c.negotiated {
+		return errors.New("compression already negotiated")
+	}
+	c.negotiated = true
+
+	if c.client {
+		if len(payload) == 0 {
+			return nil
+		}
+		if c.supports(string(payload)) {
+			c.mutex.Lock()
+			c.setAlgorithm(string(payload))
+			c.mutex.Unlock()
+			return nil
+		}
+		return fmt.Errorf("compression %v not offered", string(payload))
+	}
+
+	var chosen string
+	for _, name := range strings.Split(string(payload), ",") {
+		if c.supports(name) {
+			chosen = name
+			break
+		}
+	}
+	// the answer is written before the compressed messages
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.setAlgorithm(chosen)
+	return c.Conn.WriteMsg([]byte{flagNegotiate}, []byte(chosen))
+}
+
+func (c *CompressConn) supports(name string) bool {
+	if getCompressor(name) == nil {
+		return false
+	}
+	for _, n := range c.compression.Algorithms {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// args must not be modified by the others goroutines
+func (c *CompressConn) WriteMsg(args ...[]byte) error {
+	compressor := c.getCompressor()
+	if compressor != nil && msgLen(args) >= c.compression.Threshold {
+		data, err := compressor.Compress(args)
+		if err != nil {
+			return err
+		}
+		if len(data) < msgLen(args) {
+			return c.Conn.WriteMsg(flagsCompressed, data)
+		}
+	}
+	return c.Conn.WriteMsg(append([][]byte{flagsNone}, args...)...)
+}
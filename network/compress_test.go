@@ -0,0 +1,129 @@
+package network
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	s, c := net.Pipe()
+	msgParser := NewMsgParser()
+	server := CompressServer(newTCPConn(s, 10, msgParser, 1024, 0), &Compression{
+		Algorithms: []string{"gzip", "deflate"},
+		Threshold:  100,
+	})
+	client := CompressClient(newTCPConn(c, 10, msgParser, 1024, 0), &Compression{
+		Algorithms: []string{"zstd", "gzip"},
+		Threshold:  100,
+		MaxMsgLen:  1000,
+	})
+	defer server.Close()
+	defer client.Close()
+
+	big := bytes.Repeat([]byte("a"), 1000)
+	client.WriteMsg([]byte("hello"))
+	if b, err := server.ReadMsg(); err != nil || string(b) != "hello" {
+		t.Fatalf("server read %q: %v", b, err)
+	}
+	if server.Algorithm() != "gzip" {
+		t.Fatalf("server algorithm %q, want gzip", server.Algorithm())
+	}
+
+	server.WriteMsg(big[:500], big[500:])
+	if b, err := client.ReadMsg(); err != nil || !bytes.Equal(b, big) {
+		t.Fatalf("client read %v bytes: %v", len(b), err)
+	}
+	if client.Algorithm() != "gzip" {
+		t.Fatalf("client algorithm %q, want gzip", client.Algorithm())
+	}
+
+	client.WriteMsg(big)
+	if b, err := server.ReadMsg(); err != nil || !bytes.Equal(b, big) {
+		t.Fatalf("server read %v bytes: %v", len(b), err)
+	}
+
+	// longer than MaxMsgLen once decompressed
+	server.WriteMsg(big, []byte("a"))
+	if _, err := client.ReadMsg(); err == nil {
+		t.Fatal("client read a message too long")
+	}
+}
+
+func TestCompressor(t *testing.T) {
+	for _, name := range []string{"gzip", "deflate"} {
+		c := getCompressor(name)
+		data, err := c.Compress([][]byte{[]byte("hello "), []byte("world")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := c.Decompress(data, 100)
+		if err != nil || string(b) != "hello world" {
+			t.Fatalf("%v: decompressed %q: %v", name, b, err)
+		}
+	}
+}
+
+// the messages read are released to the test
+type releaseConn struct {
+	Conn
+	msgs     [][]byte
+	released [][]byte
+}
+
+func (c *releaseConn) ReadMsg() ([]byte, error) {
+	b := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return b, nil
+}
+
+func (c *releaseConn) WriteMsg(args ...[]byte) error {
+	return nil
+}
+
+func (c *releaseConn) ReleaseMsg(b []byte) {
+	c.released = append(c.released, b)
+}
+
+func TestCompressRelease(t *testing.T) {
+	big := bytes.Repeat([]byte("a"), 1000)
+	data, err := getCompressor("gzip").Compress([][]byte{big})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := append([]byte{0}, "hello"...)
+	compressed := append([]byte{flagCompressed}, data...)
+	conn := &releaseConn{msgs: [][]byte{
+		append([]byte{flagNegotiate}, "gzip"...),
+		plain,
+		compressed,
+	}}
+	server := CompressServer(conn, &Compression{Algorithms: []string{"gzip"}})
+
+	// the offer is released once handled
+	b, err := server.ReadMsg()
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("read %q: %v", b, err)
+	}
+	if len(conn.released) != 1 {
+		t.Fatalf("released %v messages, want 1", len(conn.released))
+	}
+	server.ReleaseMsg(b)
+	if len(conn.released) != 2 || &conn.released[1][0] != &plain[0] {
+		t.Fatal("uncompressed message not passed through")
+	}
+
+	// the compressed message is released once decompressed, the result
+	// goes to the pool
+	b, err = server.ReadMsg()
+	if err != nil || !bytes.Equal(b, big) {
+		t.Fatalf("read %v bytes: %v", len(b), err)
+	}
+	if len(conn.released) != 3 || &conn.released[2][0] != &compressed[0] {
+		t.Fatal("compressed message not released")
+	}
+	server.ReleaseMsg(b)
+	if len(conn.released) != 3 {
+		t.Fatal("decompressed message passed through")
+	}
+}
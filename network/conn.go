@@ -2,6 +2,7 @@ package network
 
 import (
 	"net"
+	"time"
 )
 
 type Conn interface {
@@ -12,3 +13,21 @@ type Conn interface {
 	Close()
 	Destroy()
 }
+
+// a Conn whose reads can time out, TCPConn and WSConn for example
+type ReadDeadliner interface {
+	// a zero t means no deadline
+	SetReadDeadline(t time.Time) error
+}
+
+// a Conn whose messages may be borrowed from a BufferPool
+type MsgReleaser interface {
+	// b is a message of ReadMsg, it must not be used after the call
+	ReleaseMsg(b []byte)
+}
+
+// a Conn with a bounded write queue, a write to a full queue closes the Conn
+type WriteQueue interface {
+	// the messages that can be queued now, -1 if unknown
+	WriteQueueFree() int
+}
@@ -157,6 +157,12 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	panic("bug")
 }
 
+// json.RawMessage copies the data, the messages keep no reference to it
+// goroutine safe
+func (p *Processor) UnmarshalBorrowed(data []byte) (interface{}, error) {
+	return p.Unmarshal(data)
+}
+
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	msgType := reflect.TypeOf(msg)
@@ -8,3 +8,11 @@ type Processor interface {
 	// must goroutine safe
 	Marshal(msg interface{}) ([][]byte, error)
 }
+
+// a Processor whose messages keep no reference to the data unmarshaled, the
+// data may be borrowed and released once routed
+type BorrowProcessor interface {
+	Processor
+	// must goroutine safe
+	UnmarshalBorrowed(data []byte) (interface{}, error)
+}
@@ -156,6 +156,17 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	}
 }
 
+// the raw messages copy the data
+// goroutine safe
+func (p *Processor) UnmarshalBorrowed(data []byte) (interface{}, error) {
+	msg, err := p.Unmarshal(data)
+	if msgRaw, ok := msg.(MsgRaw); ok {
+		msgRaw.msgRawData = append([]byte(nil), msgRaw.msgRawData...)
+		return msgRaw, err
+	}
+	return msg, err
+}
+
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	msgType := reflect.TypeOf(msg)
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 	"time"
@@ -16,16 +17,30 @@ type TCPClient struct {
 	PendingWriteNum int
 	AutoReconnect   bool
 	NewAgent        func(*TCPConn) Agent
-	conns           ConnSet
-	wg              sync.WaitGroup
-	closeFlag       bool
+	// TLS if set, else the session cipher if SessionCipher, see CipherConn
+	TLSConfig     *tls.Config
+	SessionCipher bool
+	conns         ConnSet
+	wg            sync.WaitGroup
+	closeFlag     bool
+
+	// the queued messages are written together, up to MaxBatchSize bytes, and
+	// the writer waits FlushDelay at most for more messages, 0 for no wait
+	MaxBatchSize int
+	FlushDelay   time.Duration
 
 	// msg parser
 	LenMsgLen    int
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
-	msgParser    *MsgParser
+	// optional, the messages read are borrowed from the pool, with a
+	// FrameCodec only if it has SetBufferPool, as VarintCodec and HeaderCodec
+	ReadBufferPool *BufferPool
+
+	// optional, replaces the msg parser
+	FrameCodec FrameCodec
+	codec      FrameCodec
 }
 
 func (client *TCPClient) Start() {
@@ -53,6 +68,10 @@ func (client *TCPClient) init() {
 		client.PendingWriteNum = 100
 		log.Infof("invalid PendingWriteNum, reset to %v", client.PendingWriteNum)
 	}
+	if client.MaxBatchSize <= 0 {
+		client.MaxBatchSize = 64 * 1024
+		log.Infof("invalid MaxBatchSize, reset to %v", client.MaxBatchSize)
+	}
 	if client.NewAgent == nil {
 		log.Fatal("NewAgent must not be nil")
 	}
@@ -63,16 +82,23 @@ func (client *TCPClient) init() {
 	client.conns = make(ConnSet)
 	client.closeFlag = false
 
+	if client.FrameCodec != nil {
+		client.codec = client.FrameCodec
+		setReadBufferPool(client.codec, client.ReadBufferPool)
+		return
+	}
+
 	// msg parser
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
 	msgParser.SetByteOrder(client.LittleEndian)
-	client.msgParser = msgParser
+	msgParser.SetBufferPool(client.ReadBufferPool)
+	client.codec = msgParser
 }
 
 func (client *TCPClient) dial() net.Conn {
 	for {
-		conn, err := net.Dial("tcp", client.Addr)
+		conn, err := client.dialConn()
 		if err == nil || client.closeFlag {
 			return conn
 		}
@@ -83,6 +109,18 @@ func (client *TCPClient) dial() net.Conn {
 	}
 }
 
+func (client *TCPClient) dialConn() (net.Conn, error) {
+	if client.TLSConfig != nil {
+		return tls.Dial("tcp", client.Addr, client.TLSConfig)
+	}
+
+	conn, err := net.Dial("tcp", client.Addr)
+	if err != nil || !client.SessionCipher {
+		return conn, err
+	}
+	return NewCipherClient(conn), nil
+}
+
 func (client *TCPClient) connect() {
 	defer client.wg.Done()
 
@@ -101,7 +139,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.msgParser)
+	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.codec, client.MaxBatchSize, client.FlushDelay)
 	agent := client.NewAgent(tcpConn)
 	agent.Run()
 
@@ -1,8 +1,11 @@
 package network
 
 import (
+	"bufio"
+	"crypto/tls"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/hongjie104/leaf/log"
 )
@@ -12,25 +15,58 @@ type ConnSet map[net.Conn]struct{}
 type TCPConn struct {
 	sync.Mutex
 	conn      net.Conn
-	writeChan chan []byte
+	writeChan chan writeBuf
 	closeFlag bool
-	msgParser *MsgParser
+	codec     FrameCodec
+	reader    *bufio.Reader
 }
 
-func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
+// a pooled buffer is released once written
+type writeBuf struct {
+	b      []byte
+	pooled bool
+}
+
+func newTCPConn(conn net.Conn, pendingWriteNum int, codec FrameCodec, maxBatchSize int, flushDelay time.Duration) *TCPConn {
 	tcpConn := new(TCPConn)
 	tcpConn.conn = conn
-	tcpConn.writeChan = make(chan []byte, pendingWriteNum)
-	tcpConn.msgParser = msgParser
+	tcpConn.writeChan = make(chan writeBuf, pendingWriteNum)
+	tcpConn.codec = codec
 
 	go func() {
-		for b := range tcpConn.writeChan {
-			if b == nil {
+		batch := make([]writeBuf, 0, 16)
+		var bufs net.Buffers
+		for wb := range tcpConn.writeChan {
+			if wb.b == nil {
 				break
 			}
 
-			_, err := conn.Write(b)
-			if err != nil {
+			var more bool
+			batch, more = tcpConn.collect(append(batch[:0], wb), maxBatchSize, flushDelay)
+
+			var err error
+			if len(batch) == 1 {
+				_, err = conn.Write(batch[0].b)
+			} else if _, ok := conn.(*net.TCPConn); ok {
+				// writev
+				bufs = bufs[:0]
+				for _, wb := range batch {
+					bufs = append(bufs, wb.b)
+				}
+				_, err = bufs.WriteTo(conn)
+			} else {
+				// one record for a TLS or cipher connection
+				b := joinBatch(batch)
+				_, err = conn.Write(b)
+				writeBufferPool.Put(b)
+			}
+			for i, wb := range batch {
+				if wb.pooled {
+					writeBufferPool.Put(wb.b)
+				}
+				batch[i] = writeBuf{}
+			}
+			if err != nil || !more {
 				break
 			}
 		}
@@ -44,8 +80,78 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPCo
 	return tcpConn
 }
 
+func joinBatch(batch []writeBuf) []byte {
+	var n int
+	for _, wb := range batch {
+		n += len(wb.b)
+	}
+	b := writeBufferPool.Get(n)
+	n = 0
+	for _, wb := range batch {
+		n += copy(b[n:], wb.b)
+	}
+	return b
+}
+
+// the queued messages are written together, up to maxBatchSize bytes, and
+// the writer waits flushDelay at most for more
+// false if the connection is closing
+func (tcpConn *TCPConn) collect(batch []writeBuf, maxBatchSize int, flushDelay time.Duration) ([]writeBuf, bool) {
+	size := len(batch[0].b)
+	var flush <-chan time.Time
+	if flushDelay > 0 {
+		t := time.NewTimer(flushDelay)
+		defer t.Stop()
+		flush = t.C
+	}
+
+	for size < maxBatchSize {
+		var wb writeBuf
+		var ok bool
+		select {
+		case wb, ok = <-tcpConn.writeChan:
+		default:
+			if flush == nil {
+				return batch, true
+			}
+			select {
+			case wb, ok = <-tcpConn.writeChan:
+			case <-flush:
+				return batch, true
+			}
+		}
+		if !ok || wb.b == nil {
+			return batch, false
+		}
+		batch = append(batch, wb)
+		size += len(wb.b)
+	}
+	return batch, true
+}
+
+// the TCP connection under TLS and CipherConn
+func rawConn(conn net.Conn) net.Conn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *CipherConn:
+			conn = c.Conn
+		default:
+			return conn
+		}
+	}
+}
+
+// the unsent data is discarded on close
+func setLinger(conn net.Conn) {
+	if c, ok := rawConn(conn).(*net.TCPConn); ok {
+		c.SetLinger(0)
+	}
+}
+
 func (tcpConn *TCPConn) doDestroy() {
-	tcpConn.conn.(*net.TCPConn).SetLinger(0)
+	setLinger(tcpConn.conn)
 	tcpConn.conn.Close()
 
 	if !tcpConn.closeFlag {
@@ -68,35 +174,73 @@ func (tcpConn *TCPConn) Close() {
 		return
 	}
 
-	tcpConn.doWrite(nil)
+	tcpConn.doWrite(writeBuf{})
 	tcpConn.closeFlag = true
 }
 
-func (tcpConn *TCPConn) doWrite(b []byte) {
+func (tcpConn *TCPConn) doWrite(wb writeBuf) {
 	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
 		log.Debug("close conn: channel full")
 		tcpConn.doDestroy()
 		return
 	}
 
-	tcpConn.writeChan <- b
+	tcpConn.writeChan <- wb
+}
+
+func (tcpConn *TCPConn) WriteQueueFree() int {
+	return cap(tcpConn.writeChan) - len(tcpConn.writeChan)
 }
 
 // b must not be modified by the others goroutines
 func (tcpConn *TCPConn) Write(b []byte) {
+	tcpConn.write(writeBuf{b: b})
+}
+
+// b is released to the buffer pool once written
+func (tcpConn *TCPConn) writePooled(b []byte) {
+	tcpConn.write(writeBuf{b: b, pooled: true})
+}
+
+func (tcpConn *TCPConn) write(wb writeBuf) {
 	tcpConn.Lock()
 	defer tcpConn.Unlock()
-	if tcpConn.closeFlag || b == nil {
+	if tcpConn.closeFlag || wb.b == nil {
+		if wb.pooled {
+			writeBufferPool.Put(wb.b)
+		}
 		return
 	}
 
-	tcpConn.doWrite(b)
+	tcpConn.doWrite(wb)
 }
 
+// goroutine not safe
 func (tcpConn *TCPConn) Read(b []byte) (int, error) {
+	if tcpConn.reader != nil {
+		return tcpConn.reader.Read(b)
+	}
 	return tcpConn.conn.Read(b)
 }
 
+// the reads are buffered from the first call on
+// goroutine not safe
+func (tcpConn *TCPConn) ReadByte() (byte, error) {
+	return tcpConn.bufReader().ReadByte()
+}
+
+func (tcpConn *TCPConn) bufReader() *bufio.Reader {
+	if tcpConn.reader == nil {
+		tcpConn.reader = bufio.NewReader(tcpConn.conn)
+	}
+	return tcpConn.reader
+}
+
+// a zero t means no deadline
+func (tcpConn *TCPConn) SetReadDeadline(t time.Time) error {
+	return tcpConn.conn.SetReadDeadline(t)
+}
+
 func (tcpConn *TCPConn) LocalAddr() net.Addr {
 	return tcpConn.conn.LocalAddr()
 }
@@ -106,9 +250,16 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 }
 
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
-	return tcpConn.msgParser.Read(tcpConn)
+	return tcpConn.codec.Read(tcpConn)
+}
+
+// b is a message of ReadMsg, it must not be used after the call
+func (tcpConn *TCPConn) ReleaseMsg(b []byte) {
+	if r, ok := tcpConn.codec.(interface{ Release(b []byte) }); ok {
+		r.Release(b)
+	}
 }
 
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
-	return tcpConn.msgParser.Write(tcpConn, args...)
+	return tcpConn.codec.Write(tcpConn, args...)
 }
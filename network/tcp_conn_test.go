@@ -0,0 +1,46 @@
+package network
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPConnBatch(t *testing.T) {
+	for _, c := range []struct {
+		maxBatchSize int
+		flushDelay   time.Duration
+	}{
+		{1, 0},
+		{64 * 1024, 0},
+		{100, time.Millisecond},
+	} {
+		server, client := net.Pipe()
+		msgParser := NewMsgParser()
+		w := newTCPConn(server, 1000, msgParser, c.maxBatchSize, c.flushDelay)
+		r := newTCPConn(client, 1, msgParser, c.maxBatchSize, 0)
+
+		for i := 0; i < 500; i++ {
+			if err := w.WriteMsg([]byte("msg "), []byte(fmt.Sprint(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+
+		for i := 0; i < 500; i++ {
+			b, err := r.ReadMsg()
+			if err != nil {
+				t.Fatalf("batch %v: %v", c.maxBatchSize, err)
+			}
+			if want := fmt.Sprint("msg ", i); string(b) != want {
+				t.Fatalf("batch %v: read %q, want %q", c.maxBatchSize, b, want)
+			}
+		}
+		if _, err := r.ReadMsg(); err != io.EOF {
+			t.Fatalf("batch %v: read after close: %v", c.maxBatchSize, err)
+		}
+		r.Close()
+	}
+}
@@ -15,6 +15,7 @@ type MsgParser struct {
 	minMsgLen    uint32
 	maxMsgLen    uint32
 	littleEndian bool
+	bufferPool   *BufferPool
 }
 
 func NewMsgParser() *MsgParser {
@@ -61,6 +62,12 @@ func (p *MsgParser) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+// the messages read are borrowed from pool, see TCPConn.ReleaseMsg
+// It's dangerous to call the method on reading or writing
+func (p *MsgParser) SetBufferPool(pool *BufferPool) {
+	p.bufferPool = pool
+}
+
 // goroutine safe
 func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 	var b [4]byte
@@ -98,14 +105,28 @@ func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
 	}
 
 	// data
-	msgData := make([]byte, msgLen)
+	var msgData []byte
+	if p.bufferPool != nil {
+		msgData = p.bufferPool.Get(int(msgLen))
+	} else {
+		msgData = make([]byte, msgLen)
+	}
 	if _, err := io.ReadFull(conn, msgData); err != nil {
+		p.Release(msgData)
 		return nil, err
 	}
 
 	return msgData, nil
 }
 
+// b is a message of Read, it must not be used after the call
+// goroutine safe
+func (p *MsgParser) Release(b []byte) {
+	if p.bufferPool != nil {
+		p.bufferPool.Put(b)
+	}
+}
+
 // goroutine safe
 func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 	// get len
@@ -121,8 +142,7 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 		return errors.New("message too short")
 	}
 
-	// msgLen += 2
-	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
+	msg := writeBufferPool.Get(p.lenMsgLen + int(msgLen))
 
 	// write len and Protocal
 	switch p.lenMsgLen {
@@ -150,7 +170,7 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	conn.Write(msg)
+	conn.writePooled(msg)
 
 	return nil
 }
@@ -0,0 +1,72 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// reads the same message forever
+type loopConn struct {
+	net.Conn
+	msg []byte
+	off int
+}
+
+func (c *loopConn) Read(b []byte) (int, error) {
+	n := copy(b, c.msg[c.off:])
+	c.off = (c.off + n) % len(c.msg)
+	return n, nil
+}
+
+func (c *loopConn) Close() error {
+	return nil
+}
+
+func benchmarkRead(b *testing.B, pool *BufferPool) {
+	msg := make([]byte, 2+1024)
+	binary.BigEndian.PutUint16(msg, 1024)
+
+	msgParser := NewMsgParser()
+	msgParser.SetBufferPool(pool)
+	conn := newTCPConn(&loopConn{msg: msg}, 1, msgParser, 1, 0)
+	defer conn.Close()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := conn.ReadMsg()
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn.ReleaseMsg(data)
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b, nil)
+}
+
+func BenchmarkReadPooled(b *testing.B) {
+	benchmarkRead(b, NewBufferPool(64, 64*1024))
+}
+
+func TestReadPooled(t *testing.T) {
+	msg := []byte{0, 3, 'a', 'b', 'c'}
+	msgParser := NewMsgParser()
+	msgParser.SetBufferPool(NewBufferPool(64, 1024))
+	conn := newTCPConn(&loopConn{msg: msg}, 1, msgParser, 1, 0)
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		data, err := conn.ReadMsg()
+		if err != nil || string(data) != "abc" {
+			t.Fatalf("read %q: %v", data, err)
+		}
+		if cap(data) != 64 {
+			t.Fatalf("cap %v, want 64", cap(data))
+		}
+		conn.ReleaseMsg(data)
+	}
+}
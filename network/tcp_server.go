@@ -1,11 +1,14 @@
 package network
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/util"
 )
 
 type TCPServer struct {
@@ -13,18 +16,38 @@ type TCPServer struct {
 	MaxConnNum      int
 	PendingWriteNum int
 	NewAgent        func(*TCPConn) Agent
-	ln              net.Listener
-	conns           ConnSet
-	mutexConns      sync.Mutex
-	wgLn            sync.WaitGroup
-	wgConns         sync.WaitGroup
+	// accepted connections per second, 0 for no limit
+	AcceptRate  float64
+	AcceptBurst int
+	// TLS if set, else the session cipher if SessionCipher, see CipherConn
+	CertFile      string
+	KeyFile       string
+	SessionCipher bool
+	acceptLimit   *util.TokenBucket
+	rejected      uint64
+	ln            net.Listener
+	conns         ConnSet
+	mutexConns    sync.Mutex
+	wgLn          sync.WaitGroup
+	wgConns       sync.WaitGroup
+
+	// the queued messages are written together, up to MaxBatchSize bytes, and
+	// the writer waits FlushDelay at most for more messages, 0 for no wait
+	MaxBatchSize int
+	FlushDelay   time.Duration
 
 	// msg parser
 	LenMsgLen    int
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
-	msgParser    *MsgParser
+	// optional, the messages read are borrowed from the pool, with a
+	// FrameCodec only if it has SetBufferPool, as VarintCodec and HeaderCodec
+	ReadBufferPool *BufferPool
+
+	// optional, replaces the msg parser
+	FrameCodec FrameCodec
+	codec      FrameCodec
 }
 
 func (server *TCPServer) Start() {
@@ -46,18 +69,43 @@ func (server *TCPServer) init() {
 		server.PendingWriteNum = 100
 		log.Infof("invalid PendingWriteNum, reset to %v", server.PendingWriteNum)
 	}
+	if server.MaxBatchSize <= 0 {
+		server.MaxBatchSize = 64 * 1024
+		log.Infof("invalid MaxBatchSize, reset to %v", server.MaxBatchSize)
+	}
 	if server.NewAgent == nil {
 		log.Fatal("NewAgent must not be nil")
 	}
 
+	if server.CertFile != "" || server.KeyFile != "" {
+		config := &tls.Config{}
+		config.Certificates = make([]tls.Certificate, 1)
+		config.Certificates[0], err = tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		ln = tls.NewListener(ln, config)
+	}
+
 	server.ln = ln
 	server.conns = make(ConnSet)
+	if server.AcceptRate > 0 {
+		server.acceptLimit = util.NewTokenBucket(server.AcceptRate, server.AcceptBurst)
+	}
+
+	if server.FrameCodec != nil {
+		server.codec = server.FrameCodec
+		setReadBufferPool(server.codec, server.ReadBufferPool)
+		return
+	}
 
 	// msg parser
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
 	msgParser.SetByteOrder(server.LittleEndian)
-	server.msgParser = msgParser
+	msgParser.SetBufferPool(server.ReadBufferPool)
+	server.codec = msgParser
 }
 
 func (server *TCPServer) run() {
@@ -85,6 +133,13 @@ func (server *TCPServer) run() {
 		}
 		tempDelay = 0
 
+		if server.acceptLimit != nil && !server.acceptLimit.Allow() {
+			atomic.AddUint64(&server.rejected, 1)
+			conn.Close()
+			log.Debug("accept rate exceeded")
+			continue
+		}
+
 		server.mutexConns.Lock()
 		if len(server.conns) >= server.MaxConnNum {
 			server.mutexConns.Unlock()
@@ -97,7 +152,11 @@ func (server *TCPServer) run() {
 
 		server.wgConns.Add(1)
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
+		var c net.Conn = conn
+		if server.SessionCipher && server.CertFile == "" && server.KeyFile == "" {
+			c = NewCipherServer(conn)
+		}
+		tcpConn := newTCPConn(c, server.PendingWriteNum, server.codec, server.MaxBatchSize, server.FlushDelay)
 		agent := server.NewAgent(tcpConn)
 		go func() {
 			agent.Run()
@@ -114,6 +173,12 @@ func (server *TCPServer) run() {
 	}
 }
 
+// the connections closed by the accept rate limit
+// goroutine safe
+func (server *TCPServer) Rejected() uint64 {
+	return atomic.LoadUint64(&server.rejected)
+}
+
 func (server *TCPServer) Close() {
 	server.ln.Close()
 	server.wgLn.Wait()
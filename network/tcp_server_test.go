@@ -0,0 +1,152 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type echoAgent struct {
+	conn *TCPConn
+}
+
+func (a *echoAgent) Run() {
+	for {
+		b, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg(b)
+	}
+}
+
+func (a *echoAgent) OnClose() {}
+
+type pingAgent struct {
+	conn *TCPConn
+	pong chan string
+}
+
+func (a *pingAgent) Run() {
+	a.conn.WriteMsg([]byte("ping"))
+	b, err := a.conn.ReadMsg()
+	if err != nil {
+		a.pong <- err.Error()
+		return
+	}
+	a.pong <- string(b)
+}
+
+func (a *pingAgent) OnClose() {}
+
+func writeCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	return certFile, keyFile
+}
+
+func TestTCPServerEncryption(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	for _, c := range []struct {
+		name     string
+		certFile string
+		keyFile  string
+		config   *tls.Config
+		cipher   bool
+	}{
+		{"tls", certFile, keyFile, &tls.Config{InsecureSkipVerify: true}, false},
+		{"cipher", "", "", nil, true},
+	} {
+		server := &TCPServer{
+			Addr:            "127.0.0.1:0",
+			MaxConnNum:      10,
+			PendingWriteNum: 10,
+			MaxBatchSize:    1024,
+			CertFile:        c.certFile,
+			KeyFile:         c.keyFile,
+			SessionCipher:   c.cipher,
+			NewAgent: func(conn *TCPConn) Agent {
+				return &echoAgent{conn}
+			},
+		}
+		server.Start()
+
+		pong := make(chan string, 1)
+		client := &TCPClient{
+			Addr:            server.ln.Addr().String(),
+			ConnNum:         1,
+			ConnectInterval: time.Second,
+			PendingWriteNum: 10,
+			MaxBatchSize:    1024,
+			TLSConfig:       c.config,
+			SessionCipher:   c.cipher,
+			NewAgent: func(conn *TCPConn) Agent {
+				return &pingAgent{conn, pong}
+			},
+		}
+		client.Start()
+
+		if got := <-pong; got != "ping" {
+			t.Fatalf("%v: pong %q", c.name, got)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestRawConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the linger is set on the TCP connection under the wrappers
+	for _, c := range []net.Conn{
+		conn,
+		tls.Client(conn, &tls.Config{}),
+		NewCipherClient(conn),
+		NewCipherClient(tls.Client(conn, &tls.Config{})),
+	} {
+		if rawConn(c) != conn {
+			t.Fatalf("%T not unwrapped", c)
+		}
+	}
+}
@@ -18,10 +18,14 @@ type WSClient struct {
 	HandshakeTimeout time.Duration
 	AutoReconnect    bool
 	NewAgent         func(*WSConn) Agent
-	dialer           websocket.Dialer
-	conns            WebsocketConnSet
-	wg               sync.WaitGroup
-	closeFlag        bool
+	// permessage-deflate, the messages shorter than CompressionThreshold are
+	// not compressed
+	EnableCompression    bool
+	CompressionThreshold int
+	dialer               websocket.Dialer
+	conns                WebsocketConnSet
+	wg                   sync.WaitGroup
+	closeFlag            bool
 }
 
 func (client *WSClient) Start() {
@@ -67,7 +71,9 @@ func (client *WSClient) init() {
 	client.conns = make(WebsocketConnSet)
 	client.closeFlag = false
 	client.dialer = websocket.Dialer{
-		HandshakeTimeout: client.HandshakeTimeout,
+		HandshakeTimeout:  client.HandshakeTimeout,
+		WriteBufferPool:   wsWriteBufferPool,
+		EnableCompression: client.EnableCompression,
 	}
 }
 
@@ -103,7 +109,7 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen)
+	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, client.CompressionThreshold)
 	agent := client.NewAgent(wsConn)
 	agent.Run()
 
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/hongjie104/leaf/log"
@@ -14,24 +15,34 @@ type WebsocketConnSet map[*websocket.Conn]struct{}
 type WSConn struct {
 	sync.Mutex
 	conn      *websocket.Conn
-	writeChan chan []byte
+	writeChan chan writeBuf
 	maxMsgLen uint32
 	closeFlag bool
 }
 
-func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
+// with permessage-deflate, the messages shorter than compressThreshold are
+// not compressed
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, compressThreshold int) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
-	wsConn.writeChan = make(chan []byte, pendingWriteNum)
+	wsConn.writeChan = make(chan writeBuf, pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
 
+	// each message is a websocket frame, written and flushed by itself, the
+	// queued messages are not batched like TCPConn
 	go func() {
-		for b := range wsConn.writeChan {
-			if b == nil {
+		for wb := range wsConn.writeChan {
+			if wb.b == nil {
 				break
 			}
 
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			if compressThreshold > 0 {
+				conn.EnableWriteCompression(len(wb.b) >= compressThreshold)
+			}
+			err := conn.WriteMessage(websocket.BinaryMessage, wb.b)
+			if wb.pooled {
+				writeBufferPool.Put(wb.b)
+			}
 			if err != nil {
 				break
 			}
@@ -47,7 +58,7 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSC
 }
 
 func (wsConn *WSConn) doDestroy() {
-	wsConn.conn.UnderlyingConn().(*net.TCPConn).SetLinger(0)
+	setLinger(wsConn.conn.UnderlyingConn())
 	wsConn.conn.Close()
 
 	if !wsConn.closeFlag {
@@ -70,18 +81,41 @@ func (wsConn *WSConn) Close() {
 		return
 	}
 
-	wsConn.doWrite(nil)
+	wsConn.doWrite(writeBuf{})
 	wsConn.closeFlag = true
 }
 
-func (wsConn *WSConn) doWrite(b []byte) {
+func (wsConn *WSConn) doWrite(wb writeBuf) {
 	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
 		log.Debug("close conn: channel full")
 		wsConn.doDestroy()
 		return
 	}
 
-	wsConn.writeChan <- b
+	wsConn.writeChan <- wb
+}
+
+func (wsConn *WSConn) WriteQueueFree() int {
+	return cap(wsConn.writeChan) - len(wsConn.writeChan)
+}
+
+// a zero t means no deadline
+func (wsConn *WSConn) SetReadDeadline(t time.Time) error {
+	return wsConn.conn.SetReadDeadline(t)
+}
+
+// send a ping control frame, goroutine safe
+func (wsConn *WSConn) Ping() error {
+	return wsConn.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+}
+
+// h is called by ReadMsg when a pong control frame is read
+// you must call the function before calling ReadMsg
+func (wsConn *WSConn) SetPongHandler(h func()) {
+	wsConn.conn.SetPongHandler(func(string) error {
+		h()
+		return nil
+	})
 }
 
 func (wsConn *WSConn) LocalAddr() net.Addr {
@@ -121,19 +155,19 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 
 	// don't copy
 	if len(args) == 1 {
-		wsConn.doWrite(args[0])
+		wsConn.doWrite(writeBuf{b: args[0]})
 		return nil
 	}
 
 	// merge the args
-	msg := make([]byte, msgLen)
+	msg := writeBufferPool.Get(int(msgLen))
 	l := 0
 	for i := 0; i < len(args); i++ {
 		copy(msg[l:], args[i])
 		l += len(args[i])
 	}
 
-	wsConn.doWrite(msg)
+	wsConn.doWrite(writeBuf{b: msg, pooled: true})
 
 	return nil
 }
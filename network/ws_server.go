@@ -5,10 +5,12 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/util"
 )
 
 type WSServer struct {
@@ -20,19 +22,29 @@ type WSServer struct {
 	CertFile        string
 	KeyFile         string
 	NewAgent        func(*WSConn) Agent
-	ln              net.Listener
-	handler         *WSHandler
+	// accepted connections per second, 0 for no limit
+	AcceptRate  float64
+	AcceptBurst int
+	// permessage-deflate, the messages shorter than CompressionThreshold are
+	// not compressed
+	EnableCompression    bool
+	CompressionThreshold int
+	ln                   net.Listener
+	handler              *WSHandler
 }
 
 type WSHandler struct {
-	maxConnNum      int
-	pendingWriteNum int
-	maxMsgLen       uint32
-	newAgent        func(*WSConn) Agent
-	upgrader        websocket.Upgrader
-	conns           WebsocketConnSet
-	mutexConns      sync.Mutex
-	wg              sync.WaitGroup
+	maxConnNum        int
+	pendingWriteNum   int
+	maxMsgLen         uint32
+	compressThreshold int
+	newAgent          func(*WSConn) Agent
+	acceptLimit       *util.TokenBucket
+	rejected          uint64
+	upgrader          websocket.Upgrader
+	conns             WebsocketConnSet
+	mutexConns        sync.Mutex
+	wg                sync.WaitGroup
 }
 
 func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +52,11 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
+	if handler.acceptLimit != nil && !handler.acceptLimit.Allow() {
+		atomic.AddUint64(&handler.rejected, 1)
+		http.Error(w, "Too many requests", 429)
+		return
+	}
 	conn, err := handler.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Debug("upgrade error: %v", err)
@@ -65,7 +82,7 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.conns[conn] = struct{}{}
 	handler.mutexConns.Unlock()
 
-	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen)
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, handler.compressThreshold)
 	agent := handler.newAgent(wsConn)
 	agent.Run()
 
@@ -117,16 +134,25 @@ func (server *WSServer) Start() {
 		ln = tls.NewListener(ln, config)
 	}
 
+	var acceptLimit *util.TokenBucket
+	if server.AcceptRate > 0 {
+		acceptLimit = util.NewTokenBucket(server.AcceptRate, server.AcceptBurst)
+	}
+
 	server.ln = ln
 	server.handler = &WSHandler{
-		maxConnNum:      server.MaxConnNum,
-		pendingWriteNum: server.PendingWriteNum,
-		maxMsgLen:       server.MaxMsgLen,
-		newAgent:        server.NewAgent,
-		conns:           make(WebsocketConnSet),
+		maxConnNum:        server.MaxConnNum,
+		pendingWriteNum:   server.PendingWriteNum,
+		maxMsgLen:         server.MaxMsgLen,
+		compressThreshold: server.CompressionThreshold,
+		newAgent:          server.NewAgent,
+		acceptLimit:       acceptLimit,
+		conns:             make(WebsocketConnSet),
 		upgrader: websocket.Upgrader{
-			HandshakeTimeout: server.HTTPTimeout,
-			CheckOrigin:      func(_ *http.Request) bool { return true },
+			HandshakeTimeout:  server.HTTPTimeout,
+			CheckOrigin:       func(_ *http.Request) bool { return true },
+			WriteBufferPool:   wsWriteBufferPool,
+			EnableCompression: server.EnableCompression,
 		},
 	}
 
@@ -141,6 +167,15 @@ func (server *WSServer) Start() {
 	go httpServer.Serve(ln)
 }
 
+// the connections refused by the accept rate limit
+// goroutine safe
+func (server *WSServer) Rejected() uint64 {
+	if server.handler == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&server.handler.rejected)
+}
+
 func (server *WSServer) Close() {
 	server.ln.Close()
 
@@ -0,0 +1,182 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// the source of time of a dispatcher
+type Clock interface {
+	// must goroutine safe
+	Now() time.Time
+	// must goroutine safe
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+type ClockTimer interface {
+	Stop() bool
+}
+
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// a manual clock for tests, the time only moves by Advance or Set
+// goroutine safe
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers fakeTimerHeap
+}
+
+type fakeTimer struct {
+	c     *FakeClock
+	when  time.Time
+	seq   uint64
+	f     func()
+	index int
+}
+
+type fakeTimerHeap []*fakeTimer
+
+func (h fakeTimerHeap) Len() int {
+	return len(h)
+}
+
+func (h fakeTimerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h fakeTimerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *fakeTimerHeap) Push(t interface{}) {
+	t.(*fakeTimer).index = len(*h)
+	*h = append(*h, t.(*fakeTimer))
+}
+
+func (h *fakeTimerHeap) Pop() interface{} {
+	l := len(*h)
+	t := (*h)[l-1]
+	t.index = -1
+	*h = (*h)[:l-1]
+	return t
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.now = now
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if d < 0 {
+		d = 0
+	}
+	c.seq++
+	t := &fakeTimer{c: c, when: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mutex.Lock()
+	defer t.c.mutex.Unlock()
+
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&t.c.timers, t.index)
+	return true
+}
+
+// the number of the pending timers
+func (c *FakeClock) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+// run the earliest timer due at target, moving the time to its deadline
+func (c *FakeClock) step(target time.Time) bool {
+	c.mutex.Lock()
+	if len(c.timers) == 0 || c.timers[0].when.After(target) {
+		c.mutex.Unlock()
+		return false
+	}
+	t := heap.Pop(&c.timers).(*fakeTimer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.mutex.Unlock()
+
+	t.f()
+	return true
+}
+
+// the due timers are run one by one in deadline order on the calling goroutine
+func (c *FakeClock) Advance(d time.Duration) {
+	c.AdvanceAndDispatch(d)
+}
+
+// the time never goes backwards
+func (c *FakeClock) Set(t time.Time) {
+	c.mutex.Lock()
+	d := t.Sub(c.now)
+	c.mutex.Unlock()
+
+	if d > 0 {
+		c.Advance(d)
+	}
+}
+
+// like Advance, the timers queued in the dispatchers are handled after
+// each fire, so the timers and crons they arm are run in order too
+// the dispatchers must be driven by the calling goroutine only
+func (c *FakeClock) AdvanceAndDispatch(d time.Duration, disps ...*Dispatcher) {
+	target := c.Now().Add(d)
+
+	dispatch := func() {
+		for _, disp := range disps {
+			for len(disp.ChanTimer) > 0 {
+				(<-disp.ChanTimer).Cb()
+			}
+		}
+	}
+
+	dispatch()
+	for c.step(target) {
+		dispatch()
+	}
+
+	c.mutex.Lock()
+	if target.After(c.now) {
+		c.now = target
+	}
+	c.mutex.Unlock()
+}
@@ -9,14 +9,23 @@ import (
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - ? L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-7 or SUN-SAT  | * / , - ? L #
+//
+// L   day of month: the last day, LW: the last weekday
+// nW  day of month: the weekday nearest to day n in the same month
+// nL  day of week: the last weekday n of the month
+// n#k day of week: the k-th weekday n of the month
+//
+// the expression may start with TZ=Location or CRON_TZ=Location, or be one of
+// @yearly (@annually), @monthly, @weekly, @daily (@midnight), @hourly and
+// @every duration
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -24,11 +33,87 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	domStar        bool
+	dowStar        bool
+	domLast        bool
+	domLastWeekday bool
+	domWeekday     uint64
+	dowLast        uint64
+	dowNth         [7]uint64
+
+	loc   *time.Location
+	every time.Duration
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var dowNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
 }
 
 // goroutine safe
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
+	return NewCronExprIn(expr, nil)
+}
+
+// the expression is evaluated in loc, nil means the location of the time
+// passed to Next, a TZ= prefix takes precedence
+// goroutine safe
+func NewCronExprIn(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
 	fields := strings.Fields(expr)
+
+	// time zone
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		name := fields[0][strings.Index(fields[0], "=")+1:]
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: %v", expr, err)
+			return
+		}
+		fields = fields[1:]
+	}
+
+	// macros
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		if fields[0] == "@every" {
+			if len(fields) != 2 {
+				err = fmt.Errorf("invalid expr %v: expected @every duration", expr)
+				return
+			}
+			var every time.Duration
+			every, err = time.ParseDuration(fields[1])
+			if err != nil || every < time.Second {
+				err = fmt.Errorf("invalid expr %v: invalid duration %v", expr, fields[1])
+				return
+			}
+			cronExpr = new(CronExpr)
+			cronExpr.loc = loc
+			cronExpr.every = every.Truncate(time.Second)
+			return
+		}
+
+		macro, ok := macros[fields[0]]
+		if !ok || len(fields) != 1 {
+			err = fmt.Errorf("invalid expr %v: unknown macro %v", expr, fields[0])
+			return
+		}
+		fields = strings.Fields(macro)
+	}
+
 	if len(fields) != 5 && len(fields) != 6 {
 		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
 		return
@@ -39,50 +124,157 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 	}
 
 	cronExpr = new(CronExpr)
+	cronExpr.loc = loc
 	// Seconds
-	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
+	cronExpr.sec, err = parseCronField(fields[0], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	// Minutes
-	cronExpr.min, err = parseCronField(fields[1], 0, 59)
+	cronExpr.min, err = parseCronField(fields[1], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	// Hours
-	cronExpr.hour, err = parseCronField(fields[2], 0, 23)
+	cronExpr.hour, err = parseCronField(fields[2], 0, 23, nil)
 	if err != nil {
 		goto onError
 	}
 	// Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	err = cronExpr.parseDom(fields[3])
 	if err != nil {
 		goto onError
 	}
 	// Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(fields[4], 1, 12, monthNames)
 	if err != nil {
 		goto onError
 	}
 	// Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	err = cronExpr.parseDow(fields[5])
 	if err != nil {
 		goto onError
 	}
 	return
 
 onError:
+	cronExpr = nil
 	err = fmt.Errorf("invalid expr %v: %v", expr, err)
 	return
 }
 
+// L, LW and nW
+func (e *CronExpr) parseDom(field string) (err error) {
+	if field == "?" {
+		field = "*"
+	}
+
+	var rest []string
+	for _, f := range strings.Split(field, ",") {
+		switch {
+		case f == "L":
+			e.domLast = true
+		case f == "LW":
+			e.domLastWeekday = true
+		case len(f) > 1 && strings.HasSuffix(f, "W"):
+			var n int
+			n, err = strconv.Atoi(f[:len(f)-1])
+			if err != nil || n < 1 || n > 31 {
+				return fmt.Errorf("invalid weekday: %v", f)
+			}
+			e.domWeekday |= 1 << uint(n)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	if len(rest) > 0 {
+		e.dom, err = parseCronField(strings.Join(rest, ","), 1, 31, nil)
+		if err != nil {
+			return
+		}
+	}
+	e.domStar = e.dom == 0xfffffffe && !e.domLast && !e.domLastWeekday && e.domWeekday == 0
+	return
+}
+
+// nL and n#k
+func (e *CronExpr) parseDow(field string) (err error) {
+	if field == "?" {
+		field = "*"
+	}
+
+	var rest []string
+	for _, f := range strings.Split(field, ",") {
+		switch {
+		case len(f) > 1 && strings.HasSuffix(f, "L"):
+			var n int
+			n, err = parseCronValue(f[:len(f)-1], 0, 7, dowNames)
+			if err != nil {
+				return
+			}
+			e.dowLast |= 1 << uint(n%7)
+		case strings.Contains(f, "#"):
+			dowAndNth := strings.Split(f, "#")
+			if len(dowAndNth) != 2 {
+				return fmt.Errorf("too many hashes: %v", f)
+			}
+			var n, k int
+			n, err = parseCronValue(dowAndNth[0], 0, 7, dowNames)
+			if err != nil {
+				return
+			}
+			k, err = strconv.Atoi(dowAndNth[1])
+			if err != nil || k < 1 || k > 5 {
+				return fmt.Errorf("invalid nth: %v", f)
+			}
+			e.dowNth[n%7] |= 1 << uint(k)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	if len(rest) > 0 {
+		e.dow, err = parseCronField(strings.Join(rest, ","), 0, 7, dowNames)
+		if err != nil {
+			return
+		}
+		// 7 is sunday
+		if e.dow&(1<<7) != 0 {
+			e.dow = e.dow&^(1<<7) | 1
+		}
+	}
+
+	var nth uint64
+	for _, bits := range e.dowNth {
+		nth |= bits
+	}
+	e.dowStar = e.dow == 0x7f && e.dowLast == 0 && nth == 0
+	return
+}
+
+func parseCronValue(s string, min int, max int, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToUpper(s)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %v", s)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("out of range [%v, %v]: %v", min, max, s)
+	}
+	return n, nil
+}
+
 // 1. *
 // 2. num
 // 3. num-num
 // 4. */num
 // 5. num/num (means num-max/num)
 // 6. num-num/num
-func parseCronField(field string, min int, max int) (cronField uint64, err error) {
+func parseCronField(field string, min int, max int, names map[string]int) (cronField uint64, err error) {
 	fields := strings.Split(field, ",")
 	for _, field := range fields {
 		rangeAndIncr := strings.Split(field, "/")
@@ -108,9 +300,8 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 			end = max
 		} else {
 			// start
-			start, err = strconv.Atoi(startAndEnd[0])
+			start, err = parseCronValue(startAndEnd[0], min, max, names)
 			if err != nil {
-				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 				return
 			}
 			// end
@@ -121,9 +312,8 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 					end = start
 				}
 			} else {
-				end, err = strconv.Atoi(startAndEnd[1])
+				end, err = parseCronValue(startAndEnd[1], min, max, names)
 				if err != nil {
-					err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 					return
 				}
 			}
@@ -133,14 +323,6 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 			err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 			return
 		}
-		if start < min {
-			err = fmt.Errorf("out of range [%v, %v]: %v", min, max, rangeAndIncr[0])
-			return
-		}
-		if end > max {
-			err = fmt.Errorf("out of range [%v, %v]: %v", min, max, rangeAndIncr[0])
-			return
-		}
 
 		// increment
 		var incr int
@@ -171,32 +353,137 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 	return
 }
 
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// the weekday nearest to day n, without leaving the month
+func nearestWeekday(t time.Time, n int) int {
+	days := daysIn(t)
+	if n > days {
+		return 0
+	}
+
+	switch time.Date(t.Year(), t.Month(), n, 0, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if n == 1 {
+			return 3
+		}
+		return n - 1
+	case time.Sunday:
+		if n == days {
+			return n - 2
+		}
+		return n + 1
+	}
+	return n
+}
+
+func (e *CronExpr) matchDom(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&e.dom != 0 {
+		return true
+	}
+	if e.domLast && day == daysIn(t) {
+		return true
+	}
+	if e.domLastWeekday && day == nearestWeekday(t, daysIn(t)) {
+		return true
+	}
+	if e.domWeekday != 0 {
+		for n := 1; n <= 31; n++ {
+			if 1<<uint(n)&e.domWeekday != 0 && nearestWeekday(t, n) == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	day := t.Day()
+	weekday := t.Weekday()
+	if 1<<uint(weekday)&e.dow != 0 {
+		return true
+	}
+	if 1<<uint(weekday)&e.dowLast != 0 && day+7 > daysIn(t) {
+		return true
+	}
+	return 1<<uint((day-1)/7+1)&e.dowNth[weekday] != 0
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
 	// day-of-month blank
-	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+	if e.domStar {
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
-	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+	if e.dowStar {
+		return e.matchDom(t)
 	}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+	return e.matchDow(t) || e.matchDom(t)
+}
+
+// the location the expression is evaluated in, nil means the location of
+// the time passed to Next
+func (e *CronExpr) Location() *time.Location {
+	return e.loc
 }
 
+// the result is in the location of t
+// a wall clock time skipped by a DST transition does not match, a wall clock
+// time repeated by a DST transition matches once
 // goroutine safe
 func (e *CronExpr) Next(t time.Time) time.Time {
+	if e.every > 0 {
+		return t.Truncate(time.Second).Add(e.every)
+	}
+
+	loc := e.loc
+	if loc == nil {
+		loc = t.Location()
+	}
+
+	next := t.In(loc)
+	for {
+		next = e.next(next)
+		if next.IsZero() || !repeated(next) {
+			break
+		}
+	}
+	if next.IsZero() {
+		return next
+	}
+	return next.In(t.Location())
+}
+
+// t is the second occurrence of its wall clock time
+func repeated(t time.Time) bool {
+	_, offset := t.Zone()
+	_, prevOffset := t.Add(-time.Hour).Zone()
+	if prevOffset <= offset {
+		return false
+	}
+
+	earlier := t.Add(-time.Duration(prevOffset-offset) * time.Second)
+	return earlier.Hour() == t.Hour() && earlier.Minute() == t.Minute() && earlier.Second() == t.Second()
+}
+
+func (e *CronExpr) next(t time.Time) time.Time {
+	loc := t.Location()
+
 	// the upcoming second
-	t = t.Truncate(time.Second).Add(time.Second)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
 
+	// at most one february 29 every 8 years
 	year := t.Year()
 	initFlag := false
 
 retry:
 	// Year
-	if t.Year() > year+1 {
+	if t.Year() > year+8 {
 		return time.Time{}
 	}
 
@@ -204,7 +491,7 @@ retry:
 	for 1<<uint(t.Month())&e.month == 0 {
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
 		}
 
 		t = t.AddDate(0, 1, 0)
@@ -217,10 +504,18 @@ retry:
 	for !e.matchDay(t) {
 		if !initFlag {
 			initFlag = true
-			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
 		}
 
 		t = t.AddDate(0, 0, 1)
+		// midnight might be skipped by a DST transition
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
 		if t.Day() == 1 {
 			goto retry
 		}
@@ -230,7 +525,7 @@ retry:
 	for 1<<uint(t.Hour())&e.hour == 0 {
 		if !initFlag {
 			initFlag = true
-			t = t.Truncate(time.Hour)
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
 		}
 
 		t = t.Add(time.Hour)
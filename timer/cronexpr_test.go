@@ -0,0 +1,131 @@
+package timer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/timer"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %v: %v", name, err)
+	}
+	return loc
+}
+
+func TestCronExprNext(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	shanghai := mustLoad(t, "Asia/Shanghai")
+
+	tests := []struct {
+		expr string
+		from time.Time
+		next time.Time
+	}{
+		// basics
+		{"0 * * * *", time.Date(2000, 1, 1, 20, 10, 5, 0, time.UTC), time.Date(2000, 1, 1, 21, 0, 0, 0, time.UTC)},
+		{"*/15 * * * * *", time.Date(2000, 1, 1, 0, 0, 14, 0, time.UTC), time.Date(2000, 1, 1, 0, 0, 15, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2004, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+
+		// names
+		{"0 0 1 JAN-MAR *", time.Date(2000, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * sat,SUN", time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 5, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 6, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * MON-FRI", time.Date(2021, 6, 5, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)},
+
+		// L, W and #
+		{"0 0 L * ?", time.Date(2021, 2, 3, 0, 0, 0, 0, time.UTC), time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{"0 0 L 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 LW * *", time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 7, 30, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15W * *", time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 5, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 16W * *", time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1W * *", time.Date(2021, 4, 30, 0, 0, 0, 0, time.UTC), time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31W * *", time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 10, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 ? * 5L", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 25, 0, 0, 0, 0, time.UTC)},
+		{"0 0 ? * FRI#3", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 ? * 1#5", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 8, 30, 0, 0, 0, 0, time.UTC)},
+
+		// macros
+		{"@yearly", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 6, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 6, 1, 0, 30, 0, 0, time.UTC), time.Date(2021, 6, 1, 1, 0, 0, 0, time.UTC)},
+		{"@every 5m", time.Date(2021, 6, 1, 0, 30, 10, 500, time.UTC), time.Date(2021, 6, 1, 0, 35, 10, 0, time.UTC)},
+
+		// time zones
+		{"TZ=Asia/Shanghai 0 0 * * *", time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), time.Date(2021, 6, 1, 16, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=Asia/Shanghai 0 0 * * *", time.Date(2021, 6, 1, 0, 0, 0, 0, shanghai), time.Date(2021, 6, 2, 0, 0, 0, 0, shanghai)},
+		{"TZ=Asia/Shanghai @daily", time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), time.Date(2021, 6, 1, 16, 0, 0, 0, time.UTC)},
+
+		// DST, 2021-03-14 02:00 skipped and 2021-11-07 01:00 repeated in New York
+		{"TZ=America/New_York 30 2 * * *", time.Date(2021, 3, 13, 12, 0, 0, 0, ny), time.Date(2021, 3, 15, 2, 30, 0, 0, ny)},
+		{"TZ=America/New_York 0 3 * * *", time.Date(2021, 3, 14, 0, 0, 0, 0, ny), time.Date(2021, 3, 14, 3, 0, 0, 0, ny)},
+		{"TZ=America/New_York 0 0 * * *", time.Date(2021, 3, 13, 12, 0, 0, 0, ny), time.Date(2021, 3, 14, 0, 0, 0, 0, ny)},
+		{"TZ=America/New_York 0 0 * * *", time.Date(2021, 3, 14, 0, 0, 0, 0, ny), time.Date(2021, 3, 15, 0, 0, 0, 0, ny)},
+		{"TZ=America/New_York 30 1 * * *", time.Date(2021, 11, 7, 0, 0, 0, 0, ny), time.Date(2021, 11, 7, 5, 30, 0, 0, time.UTC)},
+		{"TZ=America/New_York 30 1 * * *", time.Date(2021, 11, 7, 5, 30, 0, 0, time.UTC), time.Date(2021, 11, 8, 1, 30, 0, 0, ny)},
+		{"TZ=America/New_York 0 */1 * * *", time.Date(2021, 11, 7, 5, 0, 0, 0, time.UTC), time.Date(2021, 11, 7, 7, 0, 0, 0, time.UTC)},
+		{"TZ=America/New_York 0 12 * * *", time.Date(2021, 11, 6, 12, 0, 0, 0, ny), time.Date(2021, 11, 7, 12, 0, 0, 0, ny)},
+	}
+
+	for _, test := range tests {
+		cronExpr, err := timer.NewCronExpr(test.expr)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		next := cronExpr.Next(test.from)
+		if !next.Equal(test.next) {
+			t.Errorf("%v: next of %v is %v, want %v", test.expr, test.from, next, test.next)
+		}
+		if !next.IsZero() && next.Location() != test.from.Location() {
+			t.Errorf("%v: location %v, want %v", test.expr, next.Location(), test.from.Location())
+		}
+	}
+}
+
+func TestCronExprIn(t *testing.T) {
+	shanghai := mustLoad(t, "Asia/Shanghai")
+
+	cronExpr, err := timer.NewCronExprIn("0 0 * * *", shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := cronExpr.Next(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2021, 6, 1, 16, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next is %v, want %v", next, want)
+	}
+}
+
+func TestCronExprInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * FOO *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * 32W * *",
+		"* * * * 1#6",
+		"* * * * 1#2#3",
+		"@every",
+		"@every 10ms",
+		"@fortnightly",
+		"TZ=Nowhere/Special * * * * *",
+	}
+
+	for _, expr := range tests {
+		if _, err := timer.NewCronExpr(expr); err == nil {
+			t.Errorf("%q: error expected", expr)
+		}
+	}
+}
@@ -66,3 +66,95 @@ func ExampleCron() {
 	// Output:
 	// My name is Leaf
 }
+
+func ExampleDispatcher_Repeat() {
+	d := timer.NewDispatcher(10)
+
+	// fire 3 times
+	n := 0
+	d.Repeat(time.Millisecond, timer.FixedDelay, 3, func() {
+		n++
+		fmt.Println("tick", n)
+	})
+
+	for i := 0; i < 3; i++ {
+		(<-d.ChanTimer).Cb()
+	}
+
+	// fire until stopped
+	var t *timer.Timer
+	t = d.Every(time.Millisecond, func() {
+		fmt.Println("every", t.Fires())
+		if t.Fires() == 2 {
+			t.Stop()
+		}
+	})
+
+	for t.Fires() < 2 {
+		(<-d.ChanTimer).Cb()
+	}
+
+	// Output:
+	// tick 1
+	// tick 2
+	// tick 3
+	// every 1
+	// every 2
+}
+
+func ExampleTimer_Reset() {
+	d := timer.NewDispatcher(10)
+
+	t := d.AfterFunc(0, func() {
+		fmt.Println("My name is Leaf")
+	})
+	time.Sleep(time.Second / 10)
+
+	// the queued fire is ignored
+	t.Reset(time.Hour)
+	(<-d.ChanTimer).Cb()
+	fmt.Println(t.Remaining() > time.Hour-time.Minute)
+
+	t.Pause()
+	fmt.Println(t.Paused(), t.Remaining() > time.Hour-time.Minute)
+
+	t.Resume()
+	t.Reset(time.Millisecond)
+	(<-d.ChanTimer).Cb()
+	fmt.Println(t.Remaining())
+
+	// Output:
+	// true
+	// true true
+	// My name is Leaf
+	// 0s
+}
+
+func ExampleFakeClock() {
+	clock := timer.NewFakeClock(time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC))
+	d := timer.NewDispatcher(10)
+	d.SetClock(clock)
+
+	// daily reset
+	cronExpr, err := timer.NewCronExpr("0 0 * * *")
+	if err != nil {
+		return
+	}
+	d.CronFunc(cronExpr, func() {
+		fmt.Println("daily reset", clock.Now().Format("01-02 15:04"))
+	})
+	d.AfterFunc(36*time.Hour, func() {
+		fmt.Println("event", clock.Now().Format("01-02 15:04"))
+	})
+
+	// three days in no time
+	clock.AdvanceAndDispatch(3*24*time.Hour, d)
+	fmt.Println(clock.Now().Format("01-02 15:04"))
+
+	// Output:
+	// daily reset 01-02 00:00
+	// event 01-03 00:00
+	// daily reset 01-03 00:00
+	// daily reset 01-04 00:00
+	// 01-04 12:00
+}
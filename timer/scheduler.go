@@ -0,0 +1,438 @@
+package timer
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	"github.com/hongjie104/leaf/conf"
+	g "github.com/hongjie104/leaf/go"
+	"github.com/hongjie104/leaf/log"
+)
+
+// what to do with the fires missed while no node was running the job
+type MisfirePolicy int
+
+const (
+	// drop the missed fires and wait for the next one
+	MisfireSkip MisfirePolicy = iota
+	// run the job once for all the missed fires
+	MisfireRunOnce
+	// run the job once per missed fire, up to MaxMisfires
+	MisfireRunAll
+)
+
+// the persisted part of a job, the callback is registered again on each start
+type JobState struct {
+	ID    string
+	Spec  string
+	Next  time.Time
+	Last  time.Time
+	Fires int
+}
+
+// the store is called on the goroutines of g, one call at a time
+type JobStore interface {
+	// must goroutine safe
+	Load() ([]*JobState, error)
+	// must goroutine safe
+	Save(state *JobState) error
+	// must goroutine safe
+	Delete(id string) error
+}
+
+// a store shared by several nodes implements Leaser, the jobs only run on
+// the node holding the lease
+type Leaser interface {
+	// acquire or renew the lease for ttl, false if another owner holds it
+	// must goroutine safe
+	Lease(owner string, ttl time.Duration) (bool, error)
+	// must goroutine safe
+	Release(owner string) error
+}
+
+type job struct {
+	id      string
+	spec    string
+	expr    *CronExpr
+	at      time.Time
+	misfire MisfirePolicy
+	cb      func()
+	state   *JobState
+	t       *Timer
+}
+
+func (j *job) next(t time.Time) time.Time {
+	if j.expr == nil {
+		return time.Time{}
+	}
+	return j.expr.Next(t)
+}
+
+func (j *job) first(now time.Time) time.Time {
+	if j.expr == nil {
+		return j.at
+	}
+	return j.expr.Next(now)
+}
+
+func (j *job) stop() {
+	if j.t != nil {
+		j.t.Stop()
+		j.t = nil
+	}
+}
+
+// jobs whose schedule survives restarts
+// the store is called on the goroutines of g, so the dispatcher is never
+// blocked by it, disp and g.ChanCb must be served by the same goroutine
+// one scheduler per dispatcher (goroutine not safe)
+type Scheduler struct {
+	// a fire later than this is a misfire, 1s by default
+	MisfireThreshold time.Duration
+	// 100 by default
+	MaxMisfires int
+	// the name of this node, required if the store is a Leaser
+	Owner string
+	// 15s by default, the lease is renewed every LeaseTTL / 3
+	LeaseTTL time.Duration
+	// a finished job is kept in the store as a tombstone, so that it is not
+	// run again when registered again, and deleted after DoneTTL, 30 days by
+	// default
+	DoneTTL  time.Duration
+	disp     *Dispatcher
+	store    JobStore
+	jobIO    *g.LinearContext
+	leaseIO  *g.LinearContext
+	jobs     map[string]*job
+	states   map[string]*JobState
+	started  bool
+	leader   bool
+	loading  bool
+	renewing bool
+	// bumped by demote and Stop, the results of the store calls made before
+	// are dropped
+	gen   uint64
+	lease *Timer
+}
+
+func NewScheduler(disp *Dispatcher, g *g.Go, store JobStore) *Scheduler {
+	s := new(Scheduler)
+	s.disp = disp
+	s.store = store
+	s.jobIO = g.NewLinearContext()
+	s.leaseIO = g.NewLinearContext()
+	s.jobs = make(map[string]*job)
+	s.states = make(map[string]*JobState)
+	return s
+}
+
+// load the jobs from the store, or wait for the lease if the store is a
+// Leaser, the errors of the store are logged and the load is retried every
+// LeaseTTL / 3
+func (s *Scheduler) Start() error {
+	if s.started {
+		return errors.New("scheduler already started")
+	}
+	if s.MisfireThreshold <= 0 {
+		s.MisfireThreshold = time.Second
+	}
+	if s.MaxMisfires <= 0 {
+		s.MaxMisfires = 100
+	}
+	if s.LeaseTTL <= 0 {
+		s.LeaseTTL = 15 * time.Second
+	}
+	if s.DoneTTL <= 0 {
+		s.DoneTTL = 30 * 24 * time.Hour
+	}
+	if _, ok := s.store.(Leaser); ok && s.Owner == "" {
+		return errors.New("scheduler owner required by the lease")
+	}
+
+	s.started = true
+	s.renew()
+	s.lease = s.disp.Every(s.LeaseTTL/3, s.renew)
+	return nil
+}
+
+// the jobs are kept in the store
+func (s *Scheduler) Stop() {
+	if !s.started {
+		return
+	}
+	s.started = false
+
+	if s.lease != nil {
+		s.lease.Stop()
+		s.lease = nil
+	}
+	s.demote()
+	if leaser, ok := s.store.(Leaser); ok {
+		owner := s.Owner
+		s.leaseIO.Go(func() {
+			if err := leaser.Release(owner); err != nil {
+				log.Errorf("release lease error: %v", err)
+			}
+		}, nil)
+	}
+}
+
+// true if the jobs run on this node
+func (s *Scheduler) Leader() bool {
+	return s.leader
+}
+
+func (s *Scheduler) renew() {
+	leaser, ok := s.store.(Leaser)
+	if !ok {
+		if !s.leader {
+			s.promote()
+		}
+		return
+	}
+	// the previous renewal is still running
+	if s.renewing {
+		return
+	}
+
+	s.renewing = true
+	gen := s.gen
+	owner, ttl := s.Owner, s.LeaseTTL
+	var err error
+	s.leaseIO.Go(func() {
+		ok, err = leaser.Lease(owner, ttl)
+	}, func() {
+		if gen != s.gen {
+			return
+		}
+		s.renewing = false
+		if err != nil {
+			log.Errorf("renew lease error: %v", err)
+			ok = false
+		}
+
+		if ok && !s.leader {
+			s.promote()
+		} else if !ok && s.leader {
+			s.demote()
+		}
+	})
+}
+
+// the state of the previous leader is reloaded
+func (s *Scheduler) promote() {
+	if s.loading {
+		return
+	}
+
+	s.loading = true
+	gen := s.gen
+	expire := s.disp.clock.Now().Add(-s.DoneTTL)
+	var states []*JobState
+	var err error
+	s.jobIO.Go(func() {
+		states, err = s.load(expire)
+	}, func() {
+		if gen != s.gen {
+			return
+		}
+		s.loading = false
+		if err != nil {
+			log.Errorf("load jobs error: %v", err)
+			return
+		}
+
+		s.states = make(map[string]*JobState)
+		for _, state := range states {
+			s.states[state.ID] = state
+		}
+		s.leader = true
+		for _, j := range s.jobs {
+			s.schedule(j)
+		}
+	})
+}
+
+// the tombstones finished before expire are deleted
+func (s *Scheduler) load(expire time.Time) ([]*JobState, error) {
+	states, err := s.store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*JobState
+	for _, state := range states {
+		if state.Next.IsZero() && state.Last.Before(expire) {
+			if err := s.store.Delete(state.ID); err != nil {
+				log.Errorf("delete job %v error: %v", state.ID, err)
+			}
+			continue
+		}
+		ret = append(ret, state)
+	}
+	return ret, nil
+}
+
+func (s *Scheduler) demote() {
+	s.leader = false
+	s.loading = false
+	s.renewing = false
+	s.gen++
+	for _, j := range s.jobs {
+		j.stop()
+	}
+}
+
+// run cb on expr, the job keeps its schedule across restarts as long as
+// id and expr are unchanged
+func (s *Scheduler) Cron(id string, expr string, misfire MisfirePolicy, cb func()) error {
+	cronExpr, err := NewCronExpr(expr)
+	if err != nil {
+		return err
+	}
+
+	s.add(&job{id: id, spec: expr, expr: cronExpr, misfire: misfire, cb: cb})
+	return nil
+}
+
+// run cb once at t, the job is not run again when registered again within
+// DoneTTL after it is finished
+func (s *Scheduler) At(id string, t time.Time, misfire MisfirePolicy, cb func()) {
+	s.add(&job{id: id, spec: "@at " + t.UTC().Format(time.RFC3339Nano), at: t, misfire: misfire, cb: cb})
+}
+
+// the job is deleted from the store too
+func (s *Scheduler) Remove(id string) {
+	if j, ok := s.jobs[id]; ok {
+		j.stop()
+		delete(s.jobs, id)
+	}
+	delete(s.states, id)
+	if !s.leader {
+		return
+	}
+
+	s.jobIO.Go(func() {
+		if err := s.store.Delete(id); err != nil {
+			log.Errorf("delete job %v error: %v", id, err)
+		}
+	}, nil)
+}
+
+// the next fire of the job, zero if it is done or not scheduled on this node
+func (s *Scheduler) Next(id string) time.Time {
+	if j, ok := s.jobs[id]; ok && j.state != nil && s.leader {
+		return j.state.Next
+	}
+	return time.Time{}
+}
+
+func (s *Scheduler) add(j *job) {
+	if old, ok := s.jobs[j.id]; ok {
+		old.stop()
+	}
+	s.jobs[j.id] = j
+	if s.leader {
+		s.schedule(j)
+	}
+}
+
+func (s *Scheduler) schedule(j *job) {
+	state := s.states[j.id]
+	if state == nil || state.Spec != j.spec {
+		state = &JobState{ID: j.id, Spec: j.spec, Next: j.first(s.disp.clock.Now())}
+		s.states[j.id] = state
+		s.save(state, nil)
+	}
+	j.state = state
+	s.run(j)
+}
+
+// a copy of state is saved, then cb is called with the error
+func (s *Scheduler) save(state *JobState, cb func(err error)) {
+	st := *state
+	var err error
+	s.jobIO.Go(func() {
+		err = s.store.Save(&st)
+		if err != nil {
+			log.Errorf("save job %v error: %v", st.ID, err)
+		}
+	}, func() {
+		if cb != nil {
+			cb(err)
+		}
+	})
+}
+
+// run the due fires then arm the timer for the next one
+func (s *Scheduler) run(j *job) {
+	j.t = nil
+	if !s.leader || s.jobs[j.id] != j {
+		return
+	}
+
+	now := s.disp.clock.Now()
+	state := j.state
+	if !state.Next.IsZero() && !state.Next.After(now) {
+		runs := 1
+		if state.Next.Before(now.Add(-s.MisfireThreshold)) {
+			switch j.misfire {
+			case MisfireSkip:
+				runs = 0
+			case MisfireRunAll:
+				runs = 0
+				for t := state.Next; !t.IsZero() && !t.After(now) && runs < s.MaxMisfires; t = j.next(t) {
+					runs++
+				}
+			}
+		}
+
+		state.Next = j.next(now)
+		if runs > 0 {
+			state.Last = now
+			state.Fires += runs
+		}
+		// saved before the callback, a crash never runs a fire twice
+		// the fires are skipped if the save fails or the node is demoted
+		// in the meantime
+		gen := s.gen
+		s.save(state, func(err error) {
+			if err != nil || gen != s.gen {
+				return
+			}
+			for i := 0; i < runs; i++ {
+				exec(j.cb)
+			}
+		})
+	}
+
+	// finished, the state stays in the store as a tombstone
+	if state.Next.IsZero() {
+		delete(s.jobs, j.id)
+		return
+	}
+	s.arm(j)
+}
+
+func (s *Scheduler) arm(j *job) {
+	j.t = s.disp.AfterFunc(j.state.Next.Sub(s.disp.clock.Now()), func() {
+		s.run(j)
+	})
+}
+
+func exec(cb func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("%v: %s", r, buf[:l])
+			} else {
+				log.Errorf("%v", r)
+			}
+		}
+	}()
+
+	cb()
+}
@@ -0,0 +1,354 @@
+package timer_test
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	g "github.com/hongjie104/leaf/go"
+	"github.com/hongjie104/leaf/log"
+	"github.com/hongjie104/leaf/timer"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Logger = zap.NewNop().Sugar()
+}
+
+// a store shared by several schedulers
+type memStore struct {
+	mutex  sync.Mutex
+	states map[string]timer.JobState
+	owner  string
+	expire time.Time
+	clock  timer.Clock
+}
+
+func newMemStore(clock timer.Clock) *memStore {
+	return &memStore{states: make(map[string]timer.JobState), clock: clock}
+}
+
+func (ms *memStore) Load() ([]*timer.JobState, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	var states []*timer.JobState
+	for _, state := range ms.states {
+		state := state
+		states = append(states, &state)
+	}
+	return states, nil
+}
+
+func (ms *memStore) Save(state *timer.JobState) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.states[state.ID] = *state
+	return nil
+}
+
+func (ms *memStore) Delete(id string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	delete(ms.states, id)
+	return nil
+}
+
+func (ms *memStore) Lease(owner string, ttl time.Duration) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	now := ms.clock.Now()
+	if ms.owner != "" && ms.owner != owner && now.Before(ms.expire) {
+		return false, nil
+	}
+	ms.owner = owner
+	ms.expire = now.Add(ttl)
+	return true, nil
+}
+
+func (ms *memStore) Release(owner string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.owner == owner {
+		ms.owner = ""
+	}
+	return nil
+}
+
+type leaseless struct {
+	timer.JobStore
+}
+
+// the saves wait for release
+type blockingStore struct {
+	timer.JobStore
+	release chan struct{}
+}
+
+func (bs *blockingStore) Save(state *timer.JobState) error {
+	<-bs.release
+	return bs.JobStore.Save(state)
+}
+
+type failingStore struct {
+	timer.JobStore
+}
+
+func (fs failingStore) Save(state *timer.JobState) error {
+	return errors.New("disk full")
+}
+
+// the callbacks of the store calls are run on the test goroutine, like the
+// skeleton does
+func wait(gs ...*g.Go) {
+	for _, gc := range gs {
+		for !gc.Idle() {
+			gc.Cb(<-gc.ChanCb)
+		}
+	}
+}
+
+// the store calls are waited for after each step
+func advance(clock *timer.FakeClock, d time.Duration, step time.Duration, ds []*timer.Dispatcher, gs []*g.Go) {
+	for end := clock.Now().Add(d); clock.Now().Before(end); {
+		if end.Sub(clock.Now()) < step {
+			step = end.Sub(clock.Now())
+		}
+		clock.AdvanceAndDispatch(step, ds...)
+		wait(gs...)
+	}
+}
+
+func TestSchedulerMisfire(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	policies := []struct {
+		misfire timer.MisfirePolicy
+		runs    int
+	}{
+		{timer.MisfireSkip, 0},
+		{timer.MisfireRunOnce, 1},
+		{timer.MisfireRunAll, 3},
+	}
+
+	for _, p := range policies {
+		clock := timer.NewFakeClock(start)
+		store := timer.NewFileStore(filepath.Join(t.TempDir(), "jobs.json"))
+
+		// first run
+		d := timer.NewDispatcher(10)
+		d.SetClock(clock)
+		gs := g.New(10)
+		s := timer.NewScheduler(d, gs, store)
+		runs := 0
+		if err := s.Cron("reward", "0 0 * * *", p.misfire, func() { runs++ }); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		wait(gs)
+		clock.AdvanceAndDispatch(24*time.Hour, d)
+		wait(gs)
+		if runs != 1 {
+			t.Errorf("policy %v: %v runs on time, want 1", p.misfire, runs)
+		}
+		s.Stop()
+
+		// down for three fires
+		clock.Set(start.Add(96*time.Hour + time.Hour))
+		d = timer.NewDispatcher(10)
+		d.SetClock(clock)
+		s = timer.NewScheduler(d, gs, store)
+		runs = 0
+		s.Cron("reward", "0 0 * * *", p.misfire, func() { runs++ })
+		s.Start()
+		wait(gs)
+		if runs != p.runs {
+			t.Errorf("policy %v: %v runs after restart, want %v", p.misfire, runs, p.runs)
+		}
+		if next, want := s.Next("reward"), start.Add(120*time.Hour); !next.Equal(want) {
+			t.Errorf("policy %v: next %v, want %v", p.misfire, next, want)
+		}
+		s.Stop()
+		wait(gs)
+	}
+}
+
+func TestSchedulerAt(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := timer.NewFakeClock(start)
+	store := timer.NewFileStore(filepath.Join(t.TempDir(), "jobs.json"))
+	gs := g.New(10)
+
+	d := timer.NewDispatcher(10)
+	d.SetClock(clock)
+	s := timer.NewScheduler(d, gs, store)
+	runs := 0
+	s.At("once", start.Add(time.Hour), timer.MisfireRunOnce, func() { runs++ })
+	s.Start()
+	wait(gs)
+	clock.AdvanceAndDispatch(2*time.Hour, d)
+	wait(gs)
+	s.Stop()
+	wait(gs)
+
+	// done, not run again after a restart
+	d = timer.NewDispatcher(10)
+	d.SetClock(clock)
+	s = timer.NewScheduler(d, gs, store)
+	s.At("once", start.Add(time.Hour), timer.MisfireRunOnce, func() { runs++ })
+	s.Start()
+	wait(gs)
+	clock.AdvanceAndDispatch(2*time.Hour, d)
+	wait(gs)
+	if runs != 1 {
+		t.Errorf("%v runs, want 1", runs)
+	}
+	s.Stop()
+	wait(gs)
+
+	// the tombstone is deleted after DoneTTL
+	clock.Advance(31 * 24 * time.Hour)
+	d = timer.NewDispatcher(10)
+	d.SetClock(clock)
+	s = timer.NewScheduler(d, gs, store)
+	s.Start()
+	wait(gs)
+	if states, err := store.Load(); err != nil || len(states) != 0 {
+		t.Errorf("states %v, error %v, want none", len(states), err)
+	}
+}
+
+func TestSchedulerSave(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := timer.NewFakeClock(start)
+	store := &blockingStore{newMemStore(clock), make(chan struct{}, 10)}
+	gs := g.New(10)
+
+	d := timer.NewDispatcher(10)
+	d.SetClock(clock)
+	s := timer.NewScheduler(d, gs, leaseless{store})
+	runs := 0
+	s.Cron("tick", "0 * * * * *", timer.MisfireSkip, func() { runs++ })
+	s.Start()
+	store.release <- struct{}{}
+	wait(gs)
+
+	// the dispatcher goes on while the store is blocked, the fire runs once
+	// saved
+	ticks := 0
+	d.Every(time.Second, func() { ticks++ })
+	clock.AdvanceAndDispatch(time.Minute, d)
+	if ticks != 60 || runs != 0 {
+		t.Fatalf("%v ticks, %v runs, want 60 0", ticks, runs)
+	}
+	store.release <- struct{}{}
+	wait(gs)
+	if runs != 1 {
+		t.Fatalf("%v runs, want 1", runs)
+	}
+}
+
+func TestSchedulerSaveError(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := timer.NewFakeClock(start)
+	gs := g.New(10)
+
+	d := timer.NewDispatcher(10)
+	d.SetClock(clock)
+	s := timer.NewScheduler(d, gs, leaseless{failingStore{newMemStore(clock)}})
+	runs := 0
+	s.Cron("tick", "0 * * * * *", timer.MisfireSkip, func() { runs++ })
+	s.Start()
+	wait(gs)
+
+	// not saved, not run
+	advance(clock, 3*time.Minute, time.Second, []*timer.Dispatcher{d}, []*g.Go{gs})
+	if runs != 0 {
+		t.Fatalf("%v runs, want 0", runs)
+	}
+}
+
+func TestSchedulerSaveDemoted(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := timer.NewFakeClock(start)
+	store := &blockingStore{newMemStore(clock), make(chan struct{}, 10)}
+	gs := g.New(10)
+
+	d := timer.NewDispatcher(10)
+	d.SetClock(clock)
+	s := timer.NewScheduler(d, gs, leaseless{store})
+	runs := 0
+	s.Cron("tick", "0 * * * * *", timer.MisfireSkip, func() { runs++ })
+	s.Start()
+	store.release <- struct{}{}
+	wait(gs)
+
+	// the fire is saved after the node stepped down, another leader may
+	// run it
+	clock.AdvanceAndDispatch(time.Minute, d)
+	s.Stop()
+	store.release <- struct{}{}
+	wait(gs)
+	if runs != 0 {
+		t.Fatalf("%v runs, want 0", runs)
+	}
+}
+
+func TestSchedulerLease(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := timer.NewFakeClock(start)
+	store := newMemStore(clock)
+
+	var ds []*timer.Dispatcher
+	var gs []*g.Go
+	var ss []*timer.Scheduler
+	runs := make([]int, 2)
+	for i, owner := range []string{"node1", "node2"} {
+		i := i
+		d := timer.NewDispatcher(10)
+		d.SetClock(clock)
+		gc := g.New(10)
+		s := timer.NewScheduler(d, gc, store)
+		s.Owner = owner
+		s.LeaseTTL = 30 * time.Second
+		s.Cron("tick", "0 * * * * *", timer.MisfireSkip, func() { runs[i]++ })
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		wait(gc)
+		ds = append(ds, d)
+		gs = append(gs, gc)
+		ss = append(ss, s)
+	}
+
+	if !ss[0].Leader() || ss[1].Leader() {
+		t.Fatalf("leaders %v %v, want node1 only", ss[0].Leader(), ss[1].Leader())
+	}
+	advance(clock, 3*time.Minute, time.Second, ds, gs)
+	if runs[0] != 3 || runs[1] != 0 {
+		t.Errorf("runs %v, want [3 0]", runs)
+	}
+
+	// node1 leaves, node2 takes over on its next renewal
+	ss[0].Stop()
+	advance(clock, 3*time.Minute, time.Second, ds, gs)
+	if !ss[1].Leader() {
+		t.Fatal("node2 is not the leader")
+	}
+	if runs[0] != 3 || runs[1] != 3 {
+		t.Errorf("runs %v, want [3 3]", runs)
+	}
+
+	// a store without lease needs no owner
+	gc := g.New(10)
+	s := timer.NewScheduler(timer.NewDispatcher(10), gc, leaseless{store})
+	err := s.Start()
+	wait(gc)
+	if err != nil || !s.Leader() {
+		t.Errorf("leaseless store: leader %v, error %v", s.Leader(), err)
+	}
+}
@@ -0,0 +1,122 @@
+package timer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// a JobStore kept in a json file, for a single node
+// goroutine safe
+type FileStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewFileStore(path string) *FileStore {
+	fs := new(FileStore)
+	fs.path = path
+	return fs
+}
+
+func (fs *FileStore) Load() ([]*JobState, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	states, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*JobState
+	for _, state := range states {
+		ret = append(ret, state)
+	}
+	return ret, nil
+}
+
+func (fs *FileStore) Save(state *JobState) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	states, err := fs.load()
+	if err != nil {
+		return err
+	}
+	s := *state
+	states[s.ID] = &s
+	return fs.write(states)
+}
+
+func (fs *FileStore) Delete(id string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	states, err := fs.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := states[id]; !ok {
+		return nil
+	}
+	delete(states, id)
+	return fs.write(states)
+}
+
+func (fs *FileStore) load() (map[string]*JobState, error) {
+	states := make(map[string]*JobState)
+
+	data, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*JobState
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range list {
+		states[state.ID] = state
+	}
+	return states, nil
+}
+
+// the file is replaced as a whole, a crash never leaves it half written
+func (fs *FileStore) write(states map[string]*JobState) error {
+	list := make([]*JobState, 0, len(states))
+	for _, state := range states {
+		list = append(list, state)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
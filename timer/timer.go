@@ -2,6 +2,7 @@ package timer
 
 import (
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/hongjie104/leaf/conf"
@@ -11,28 +12,225 @@ import (
 // one dispatcher per goroutine (goroutine not safe)
 type Dispatcher struct {
 	ChanTimer chan *Timer
+	notify    func()
+	wheel     *wheel
+	clock     Clock
 }
 
 func NewDispatcher(l int) *Dispatcher {
 	disp := new(Dispatcher)
 	disp.ChanTimer = make(chan *Timer, l)
+	disp.clock = RealClock
 	return disp
 }
 
+// the timers are kept in a timing wheel instead of the runtime timer heap
+// and fire with a precision of tick
+func NewWheelDispatcher(l int, tick time.Duration) *Dispatcher {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+		log.Infof("invalid tick, reset to %v", tick)
+	}
+
+	disp := NewDispatcher(l)
+	disp.wheel = newWheel(disp, tick)
+	return disp
+}
+
+// you must call the function before calling AfterFunc and CronFunc
+func (disp *Dispatcher) SetClock(c Clock) {
+	if c == nil {
+		c = RealClock
+	}
+	disp.clock = c
+	if disp.wheel != nil {
+		disp.wheel.start = c.Now()
+	}
+}
+
+func (disp *Dispatcher) Clock() Clock {
+	return disp.clock
+}
+
+// f is called each time a timer has been queued, it must be goroutine safe
+// you must call the function before calling AfterFunc and CronFunc
+func (disp *Dispatcher) SetNotify(f func()) {
+	disp.notify = f
+}
+
+// stop the timing wheel, the pending timers never fire
+func (disp *Dispatcher) Close() {
+	if disp.wheel != nil {
+		disp.wheel.close()
+	}
+}
+
+func (disp *Dispatcher) fire(t *Timer, gen uint64) {
+	// keep the latest arm, an older fire may come late
+	for {
+		fired := atomic.LoadUint64(&t.fired)
+		if fired >= gen || atomic.CompareAndSwapUint64(&t.fired, fired, gen) {
+			break
+		}
+	}
+
+	disp.ChanTimer <- t
+	if disp.notify != nil {
+		disp.notify()
+	}
+}
+
+type RepeatMode int
+
+const (
+	// the fires are spaced by the interval whatever the callback takes
+	FixedRate RepeatMode = iota
+	// the next fire is scheduled the interval after the callback returns
+	FixedDelay
+)
+
 // Timer
 type Timer struct {
-	t  *time.Timer
-	cb func()
+	disp     *Dispatcher
+	cb       func()
+	gen      uint64
+	fired    uint64
+	active   bool
+	stopped  bool
+	paused   bool
+	left     time.Duration
+	deadline time.Time
+
+	// repeat
+	interval time.Duration
+	mode     RepeatMode
+	count    int
+	fires    int
+
+	// clock timer
+	t ClockTimer
+
+	// timing wheel
+	w      *wheel
+	wgen   uint64
+	expire uint64
+	slot   *slot
+	prev   *Timer
+	next   *Timer
+}
+
+// a fire of an older arm still queued in ChanTimer is ignored
+func (t *Timer) arm(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	t.gen++
+	t.active = true
+	t.deadline = t.disp.clock.Now().Add(d)
+
+	if t.w != nil {
+		t.w.add(t, d, t.gen)
+		return
+	}
+
+	gen := t.gen
+	t.t = t.disp.clock.AfterFunc(d, func() {
+		t.disp.fire(t, gen)
+	})
+}
+
+func (t *Timer) disarm() {
+	if t.active {
+		if t.w != nil {
+			t.w.remove(t)
+		} else {
+			t.t.Stop()
+		}
+	}
+	t.gen++
+	t.active = false
 }
 
+// safe even if the fire is already queued in ChanTimer
 func (t *Timer) Stop() {
-	t.t.Stop()
-	t.cb = nil
+	t.disarm()
+	t.stopped = true
+	t.paused = false
+}
+
+// arm the timer again to fire after d, a stopped timer is restarted
+func (t *Timer) Reset(d time.Duration) {
+	t.disarm()
+	t.stopped = false
+	t.paused = false
+	t.arm(d)
+}
+
+// the time left before the next fire, 0 if the timer is not pending
+func (t *Timer) Remaining() time.Duration {
+	if t.paused {
+		return t.left
+	}
+	if !t.active {
+		return 0
+	}
+	if d := t.deadline.Sub(t.disp.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (t *Timer) Pause() {
+	if !t.active || t.paused {
+		return
+	}
+	t.left = t.Remaining()
+	t.disarm()
+	t.paused = true
+}
+
+func (t *Timer) Resume() {
+	if !t.paused {
+		return
+	}
+	t.paused = false
+	t.arm(t.left)
+}
+
+func (t *Timer) Paused() bool {
+	return t.paused
+}
+
+// the number of fires so far
+func (t *Timer) Fires() int {
+	return t.fires
 }
 
 func (t *Timer) Cb() {
+	// stopped, reset or paused after the fire has been queued
+	if !t.active || atomic.LoadUint64(&t.fired) != t.gen {
+		return
+	}
+	t.active = false
+	t.fires++
+
+	repeat := t.interval > 0 && (t.count <= 0 || t.fires < t.count)
+	if repeat && t.mode == FixedRate {
+		t.arm(t.deadline.Add(t.interval).Sub(t.disp.clock.Now()))
+	}
+	gen := t.gen
+
+	t.exec()
+
+	// not stopped, reset or paused by the callback
+	if repeat && t.mode == FixedDelay && gen == t.gen && !t.stopped {
+		t.arm(t.interval)
+	}
+}
+
+func (t *Timer) exec() {
 	defer func() {
-		t.cb = nil
 		if r := recover(); r != nil {
 			if conf.LenStackBuf > 0 {
 				buf := make([]byte, conf.LenStackBuf)
@@ -49,12 +247,36 @@ func (t *Timer) Cb() {
 	}
 }
 
-func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+func (disp *Dispatcher) newTimer(cb func()) *Timer {
 	t := new(Timer)
+	t.disp = disp
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
-		disp.ChanTimer <- t
-	})
+	t.w = disp.wheel
+	return t
+}
+
+func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+	t := disp.newTimer(cb)
+	t.arm(d)
+	return t
+}
+
+// fire every interval in FixedRate mode until stopped
+func (disp *Dispatcher) Every(interval time.Duration, cb func()) *Timer {
+	return disp.Repeat(interval, FixedRate, 0, cb)
+}
+
+// fire every interval, count times or until stopped if count <= 0
+func (disp *Dispatcher) Repeat(interval time.Duration, mode RepeatMode, count int, cb func()) *Timer {
+	if interval <= 0 {
+		panic("invalid interval")
+	}
+
+	t := disp.newTimer(cb)
+	t.interval = interval
+	t.mode = mode
+	t.count = count
+	t.arm(interval)
 	return t
 }
 
@@ -72,7 +294,7 @@ func (c *Cron) Stop() {
 func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	c := new(Cron)
 
-	now := time.Now()
+	now := disp.clock.Now()
 	nextTime := cronExpr.Next(now)
 	if nextTime.IsZero() {
 		return c
@@ -83,7 +305,7 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	cb = func() {
 		defer _cb()
 
-		now := time.Now()
+		now := disp.clock.Now()
 		nextTime := cronExpr.Next(now)
 		if nextTime.IsZero() {
 			return
@@ -0,0 +1,208 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+// hierarchical timing wheel, 256 slots on the first level and 64 on the others
+// reference: linux kernel timer wheel
+const (
+	wheelBits0  = 8
+	wheelBits   = 6
+	wheelSize0  = 1 << wheelBits0
+	wheelSize   = 1 << wheelBits
+	wheelLevels = 5
+	wheelMax    = 1<<(wheelBits0+(wheelLevels-1)*wheelBits) - 1
+)
+
+type slot struct {
+	head *Timer
+}
+
+func (s *slot) push(t *Timer) {
+	t.slot = s
+	t.prev = nil
+	t.next = s.head
+	if s.head != nil {
+		s.head.prev = t
+	}
+	s.head = t
+}
+
+func (s *slot) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		s.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.slot = nil
+	t.prev = nil
+	t.next = nil
+}
+
+// goroutine safe
+type wheel struct {
+	sync.Mutex
+	disp      *Dispatcher
+	tick      time.Duration
+	start     time.Time
+	cur       uint64
+	levels    [wheelLevels][]slot
+	count     int
+	armed     bool
+	t         ClockTimer
+	closeFlag bool
+}
+
+func newWheel(disp *Dispatcher, tick time.Duration) *wheel {
+	w := new(wheel)
+	w.disp = disp
+	w.tick = tick
+	w.start = disp.clock.Now()
+	w.levels[0] = make([]slot, wheelSize0)
+	for i := 1; i < wheelLevels; i++ {
+		w.levels[i] = make([]slot, wheelSize)
+	}
+	return w
+}
+
+// the number of the ticks elapsed
+func (w *wheel) now() uint64 {
+	return uint64(w.disp.clock.Now().Sub(w.start) / w.tick)
+}
+
+func (w *wheel) add(t *Timer, d time.Duration, gen uint64) {
+	w.Lock()
+	defer w.Unlock()
+	if w.closeFlag {
+		return
+	}
+
+	// no timer is pending, skip the empty ticks
+	if w.count == 0 && !w.armed {
+		w.cur = w.now()
+	}
+
+	// round up, a timer never fires early
+	elapsed := w.disp.clock.Now().Sub(w.start)
+	t.wgen = gen
+	t.expire = uint64((elapsed + d + w.tick - 1) / w.tick)
+	if t.expire < w.cur {
+		t.expire = w.cur
+	}
+	w.place(t)
+	w.count++
+
+	if !w.armed {
+		w.arm()
+	}
+}
+
+func (w *wheel) place(t *Timer) {
+	expire := t.expire
+	delta := expire - w.cur
+	// out of range, placed again by the cascade
+	if delta > wheelMax {
+		delta = wheelMax
+		expire = w.cur + delta
+	}
+
+	if delta < wheelSize0 {
+		w.levels[0][expire&(wheelSize0-1)].push(t)
+		return
+	}
+	for level := 1; level < wheelLevels; level++ {
+		shift := uint(wheelBits0 + level*wheelBits)
+		if delta < 1<<shift || level == wheelLevels-1 {
+			i := (expire >> (shift - wheelBits)) & (wheelSize - 1)
+			w.levels[level][i].push(t)
+			return
+		}
+	}
+}
+
+func (w *wheel) remove(t *Timer) {
+	w.Lock()
+	defer w.Unlock()
+
+	if t.slot != nil {
+		t.slot.remove(t)
+		w.count--
+	}
+}
+
+func (w *wheel) arm() {
+	w.armed = true
+	c := w.disp.clock
+	w.t = c.AfterFunc(w.start.Add(time.Duration(w.cur+1)*w.tick).Sub(c.Now()), w.run)
+}
+
+// move the timers of the upper level slot down
+func (w *wheel) cascade(level int) bool {
+	shift := uint(wheelBits0 + (level-1)*wheelBits)
+	i := (w.cur >> shift) & (wheelSize - 1)
+	s := &w.levels[level][i]
+	for s.head != nil {
+		t := s.head
+		s.remove(t)
+		w.place(t)
+	}
+	return i == 0
+}
+
+type wheelFire struct {
+	t   *Timer
+	gen uint64
+}
+
+func (w *wheel) run() {
+	var fired []wheelFire
+
+	w.Lock()
+	if w.closeFlag {
+		w.Unlock()
+		return
+	}
+	for target := w.now(); w.cur <= target; w.cur++ {
+		if w.cur&(wheelSize0-1) == 0 {
+			for level := 1; level < wheelLevels && w.cascade(level); level++ {
+			}
+		}
+
+		s := &w.levels[0][w.cur&(wheelSize0-1)]
+		for s.head != nil {
+			t := s.head
+			s.remove(t)
+			w.count--
+			fired = append(fired, wheelFire{t, t.wgen})
+		}
+	}
+	w.Unlock()
+
+	// may block when ChanTimer is full, the wheel stays armed meanwhile
+	for _, f := range fired {
+		w.disp.fire(f.t, f.gen)
+	}
+
+	w.Lock()
+	if w.count > 0 && !w.closeFlag {
+		w.arm()
+	} else {
+		w.armed = false
+	}
+	w.Unlock()
+}
+
+func (w *wheel) close() {
+	w.Lock()
+	defer w.Unlock()
+
+	w.closeFlag = true
+	if w.t != nil {
+		w.t.Stop()
+	}
+}
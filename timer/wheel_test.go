@@ -0,0 +1,100 @@
+package timer_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/hongjie104/leaf/timer"
+)
+
+func TestWheelDispatcher(t *testing.T) {
+	d := timer.NewWheelDispatcher(100, time.Millisecond)
+	defer d.Close()
+
+	// crosses the first level of the wheel
+	durations := []time.Duration{300, 1, 50, 260, 3, 120, 256, 0}
+	start := time.Now()
+	fired := make(map[time.Duration]time.Duration)
+	for _, ms := range durations {
+		ms := ms
+		d.AfterFunc(ms*time.Millisecond, func() {
+			fired[ms] = time.Since(start)
+		})
+	}
+
+	stopped := d.AfterFunc(100*time.Millisecond, func() {
+		t.Error("stopped timer fired")
+	})
+	stopped.Stop()
+
+	var last time.Duration
+	for range durations {
+		(<-d.ChanTimer).Cb()
+		for ms, elapsed := range fired {
+			if elapsed < ms*time.Millisecond {
+				t.Errorf("timer %vms fired early: %v", ms, elapsed)
+			}
+			if ms < last {
+				t.Errorf("timer %vms fired after %vms", ms, last)
+			}
+			last = ms
+			delete(fired, ms)
+		}
+	}
+
+	select {
+	case <-d.ChanTimer:
+		t.Error("unexpected timer")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func benchmarkAfterFuncStop(b *testing.B, d *timer.Dispatcher) {
+	timers := make([]*timer.Timer, 0, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timers = append(timers, d.AfterFunc(time.Duration(rand.Intn(3600))*time.Second, nil))
+		if len(timers) == cap(timers) {
+			for _, t := range timers {
+				t.Stop()
+			}
+			timers = timers[:0]
+		}
+	}
+	for _, t := range timers {
+		t.Stop()
+	}
+}
+
+func BenchmarkRuntimeAfterFuncStop(b *testing.B) {
+	benchmarkAfterFuncStop(b, timer.NewDispatcher(0))
+}
+
+func BenchmarkWheelAfterFuncStop(b *testing.B) {
+	d := timer.NewWheelDispatcher(0, 10*time.Millisecond)
+	defer d.Close()
+	benchmarkAfterFuncStop(b, d)
+}
+
+func benchmarkFire(b *testing.B, d *timer.Dispatcher) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.AfterFunc(time.Duration(rand.Intn(50))*time.Millisecond, nil)
+	}
+	for i := 0; i < b.N; i++ {
+		(<-d.ChanTimer).Cb()
+	}
+}
+
+func BenchmarkRuntimeFire(b *testing.B) {
+	benchmarkFire(b, timer.NewDispatcher(1024))
+}
+
+func BenchmarkWheelFire(b *testing.B) {
+	d := timer.NewWheelDispatcher(1024, time.Millisecond)
+	defer d.Close()
+	benchmarkFire(b, d)
+}
@@ -2,6 +2,7 @@ package util_test
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/hongjie104/leaf/util"
 )
@@ -91,3 +92,17 @@ func ExampleDeepClone() {
 	// 2
 	// 3
 }
+
+func ExampleTokenBucket() {
+	b := util.NewTokenBucket(10, 2)
+	now := time.Now()
+
+	fmt.Println(b.AllowAt(now, 1), b.AllowAt(now, 1), b.AllowAt(now, 1))
+	fmt.Println(b.AllowAt(now.Add(100*time.Millisecond), 1))
+	fmt.Println(b.ReserveAt(now.Add(100*time.Millisecond), 1))
+
+	// Output:
+	// true true false
+	// true
+	// 100ms
+}
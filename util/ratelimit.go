@@ -0,0 +1,79 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket refills rate tokens per second up to burst
+// goroutine safe
+type TokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket NewTokenBucket, the bucket starts full
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		panic("invalid rate")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	b := new(TokenBucket)
+	b.rate = rate
+	b.burst = float64(burst)
+	b.tokens = b.burst
+	return b
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// Allow takes a token if there is one
+func (b *TokenBucket) Allow() bool {
+	return b.AllowAt(time.Now(), 1)
+}
+
+// AllowAt takes n tokens at now if there are enough
+func (b *TokenBucket) AllowAt(now time.Time, n int) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(now)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Reserve takes a token in advance and returns the wait before using it
+func (b *TokenBucket) Reserve() time.Duration {
+	return b.ReserveAt(time.Now(), 1)
+}
+
+// ReserveAt takes n tokens at now, the bucket may go into debt
+func (b *TokenBucket) ReserveAt(now time.Time, n int) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}